@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
@@ -24,7 +27,7 @@ func main() {
 	fmt.Println("[*] Counting targets...")
 	startTime := time.Now()
 
-	totalTargets, err := scanner.CountTotalTargets(cfg.IPsFile, cfg.HostsFile, len(cfg.Paths))
+	totalTargets, err := scanner.CountTotalTargets(cfg.IPsFile, cfg.HostsFile, len(cfg.Paths), len(cfg.Ports))
 	if err != nil {
 		fmt.Printf("[-] Error counting targets: %v\n", err)
 		os.Exit(1)
@@ -58,7 +61,20 @@ func main() {
 	fmt.Printf("[*] Starting scan with %d workers...\n", cfg.Concurrency)
 	scanStartTime := time.Now()
 
-	scanner.Run()
+	// The first signal stops the scan gracefully, a second one exits right away
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Println("\n[!] Stopping, waiting for requests in flight (signal again to force exit)...")
+		cancel()
+		<-signals
+		fmt.Println("\n[!] Forced exit")
+		os.Exit(130)
+	}()
+
+	scanner.Run(ctx)
 
 	fmt.Printf("[+] Completed in %s\n", time.Since(scanStartTime))
 }
@@ -1,14 +1,147 @@
 package config
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 	"strconv"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/expr"
+)
+
+// SNI modes for HTTPS requests
+const (
+	SNINone  = "none"  // No SNI is sent, only the Host header varies
+	SNIHost  = "host"  // SNI equals the candidate hostname
+	SNIFixed = "fixed" // SNI is a fixed name while the Host header varies
+)
+
+// :authority and Host of HTTP/2 requests
+const (
+	H2AuthorityHost = "host" // :authority is the candidate hostname, no Host header
+	H2AuthorityIP   = "ip"   // :authority is the IP, a Host header the candidate
+	H2AuthorityBoth = "both" // Candidate in both :authority and a Host header
+)
+
+// Proxy rotation strategies
+const (
+	ProxyRoundRobin = "round-robin" // Next proxy for every connection
+	ProxySticky     = "sticky"      // Same proxy for all connections to an IP
+)
+
+// Host headers used for redirects to other hosts
+const (
+	RedirectHostLocation = "location" // Host of the Location URL
+	RedirectHostFuzzed   = "fuzzed"   // The candidate hostname on every hop
 )
 
+// Kinds of match rules
+const (
+	MatchStatus      = "status"       // Status codes
+	MatchRegex       = "regex"        // Body regex
+	MatchWords       = "words"        // Number of words in the body
+	MatchLines       = "lines"        // Number of lines in the body
+	MatchSize        = "size"         // Body size in bytes
+	MatchHeader      = "header"       // Header presence, optionally with a value regex
+	MatchContentType = "content-type" // Content-Type substrings
+	MatchTime        = "time"         // Response time in milliseconds
+	MatchTitle       = "title"        // Title regex
+	MatchRedirect    = "redirect"     // Redirect destination regex
+)
+
+// Range is an inclusive numeric range
+type Range struct {
+	Min, Max int64
+}
+
+// MatchRule is a condition on responses given by one of the -m* or -f* flags
+type MatchRule struct {
+	Kind    string
+	Filter  bool           // Drop matching responses instead of reporting them
+	Ranges  []Range        // status, words, lines, size and time rules
+	Pattern *regexp.Regexp // regex, title and redirect rules, header value regex
+	Header  string         // Header name of header rules
+	Values  []string       // Lowercase substrings of content-type rules
+}
+
+// matchFlags are the names of the match flags per rule kind, the filter flag
+// replaces the leading m with f
+var matchFlags = []struct {
+	kind, name, usage string
+}{
+	{MatchStatus, "mc", "status codes and ranges, e.g. 200,300-399"},
+	{MatchRegex, "mr", "body regex"},
+	{MatchWords, "mw", "word counts and ranges, e.g. 10-50,>100"},
+	{MatchLines, "ml", "line counts and ranges"},
+	{MatchSize, "ms", "body sizes in bytes and ranges"},
+	{MatchHeader, "mh", "header name, optionally with a value regex, e.g. \"Server: nginx\""},
+	{MatchContentType, "mt", "comma-separated Content-Type substrings, e.g. json,html"},
+	{MatchTime, "mtime", "response times in ms and ranges, e.g. >500"},
+	{MatchTitle, "mtitle", "title regex"},
+	{MatchRedirect, "mredirect", "redirect destination (resolved Location) regex, e.g. ^https://sso\\."},
+}
+
+// Kinds of value extractors
+const (
+	ExtractRegex  = "regex"  // Regex matches, or the first group if there is one
+	ExtractHeader = "header" // Header values
+	ExtractJSON   = "json"   // Values at a path in a JSON body
+)
+
+// ExtractorSpec is a value extractor given by one of the -extract-* flags
+type ExtractorSpec struct {
+	Kind    string
+	Name    string         // Key of the captured values in results
+	Pattern *regexp.Regexp // regex extractors
+	Header  string         // header extractors
+	Path    []string       // json extractors, object keys, array indices or * for all elements
+}
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// ExprVars are the response fields available to -expr. The baseline values
+// are those of the IP's default vhost, -1 without a baseline.
+var ExprVars = map[string]expr.Type{
+	"status":          expr.Number,
+	"length":          expr.Number, // Body length
+	"words":           expr.Number,
+	"lines":           expr.Number,
+	"time":            expr.Number, // Response time in milliseconds
+	"body":            expr.String,
+	"title":           expr.String,
+	"content_type":    expr.String,
+	"headers":         expr.Map, // Case-insensitive, e.g. headers["server"]
+	"host":            expr.String,
+	"ip":              expr.String,
+	"port":            expr.Number,
+	"path":            expr.String,
+	"protocol":        expr.String,
+	"location":        expr.String, // Resolved Location of a redirect
+	"redirect":        expr.String, // Redirect class: same-host, https or off-host
+	"baseline_status": expr.Number,
+	"baseline_length": expr.Number,
+	"baseline_words":  expr.Number,
+	"baseline_lines":  expr.Number,
+	"similarity":      expr.Number, // Similarity to the baseline in percent
+}
+
 type Config struct {
 	IPsFile             string
 	HostsFile           string
@@ -25,6 +158,39 @@ type Config struct {
 	Protocols           []string // Change to slice of strings
 	RateLimit           int
 	FollowRedirects     bool // Add this field
+	MaxRedirects        int
+	RedirectHost        string
+	RedirectSameIP      bool // Every hop connects to the target IP
+	Compressed          bool // Send Accept-Encoding, compressed bodies are decoded either way
+	KeepAlive           bool // Reuse connections instead of closing them after every request
+	MaxConnsPerHost     int  // Connections per IP:port in keep-alive mode, 0 for the fasthttp default
+	Pipeline            int  // Requests pipelined per connection, 0 to disable pipelining
+	H2Authority         string
+	Proxies             []*url.URL
+	ProxyRotation       string
+	ProxyMaxFailures    int // Consecutive failures after which a proxy is retired
+	Resolvers           []string            // DNS servers as host:port, empty for the system resolver
+	DNSHosts            map[string][]net.IP // Static addresses per lowercase hostname
+	AutoCalibrate       bool
+	CalibrationRequests int
+	SimilarityThreshold int
+	OutputFile          string
+	OutputFormats       []string
+	Ports               []int
+	HostHeaderPort      bool
+	DetectProtocol      bool
+	SNIMode             string
+	SNIName             string
+	HarvestCerts        bool
+	HarvestOutput       string
+	Recursive           bool
+	RecursiveScope      []string
+	RecursiveDepth      int
+	ResumeFile          string
+	MatchRules          []MatchRule
+	MatchAll            bool // Responses must meet all matchers instead of any (-mc-condition and)
+	Expr                *expr.Program
+	Extractors          []ExtractorSpec
 }
 
 func ParseFlags() Config {
@@ -33,12 +199,22 @@ func ParseFlags() Config {
 	var protocolStr string // Change to string to handle multiple protocols
 	var requestTimeout, maxIdleConnDuration, maxConnDuration, readTimeout, writeTimeout int
 	var httpStatusIsStr string
+	var outputFormatsStr string
+	var portsStr string
+	var scopeStr string
+	var proxyStr string
+	var resolversStr, resolversFile, dnsHostsFile string
+	var matchConditionStr string
+	var exprStr string
+	var extractRegexes, extractHeaders, extractJSONs stringList
+	matchValues := make(map[string]*string)
+	filterValues := make(map[string]*string)
 
 	flag.StringVar(&config.IPsFile, "ips", "", "File containing IP addresses")
 	flag.StringVar(&config.HostsFile, "hosts", "", "File containing hostnames")
 	flag.IntVar(&config.Concurrency, "concurrency", 100, "Number of concurrent requests")
 	flag.StringVar(&pathsStr, "paths", "/", "Comma-separated list of paths to check")
-	flag.StringVar(&protocolStr, "protocol", "http", "Comma-separated list of protocols (http,https)")
+	flag.StringVar(&protocolStr, "protocol", "http", "Comma-separated list of protocols (http,https,h2,h2c)")
 	flag.StringVar(&config.HTTPBodyIncludes, "http-body-includes", "", "String to search for in response body")
 	flag.StringVar(&httpStatusIsStr, "http-status-is", "", "Comma-separated list of expected HTTP status codes")
 	flag.IntVar(&requestTimeout, "request-timeout", 4, "Timeout for individual requests in seconds")
@@ -49,6 +225,45 @@ func ParseFlags() Config {
 	flag.BoolVar(&config.Verbose, "verbose", false, "Show all requests and responses")
 	flag.IntVar(&config.RateLimit, "rate-limit", 0, "Rate limit in requests per second (0 for no limit)")
 	flag.BoolVar(&config.FollowRedirects, "redirect", false, "Follow HTTP redirects") // Add this flag
+	flag.IntVar(&config.MaxRedirects, "max-redirects", 5, "Maximum number of redirects followed with -redirect")
+	flag.StringVar(&config.RedirectHost, "redirect-host", RedirectHostLocation, "Host header for redirects to other hosts: location (Location host) or fuzzed (keep the candidate hostname)")
+	flag.BoolVar(&config.RedirectSameIP, "redirect-same-ip", false, "Send every redirect hop to the target IP instead of the Location host")
+	flag.StringVar(&proxyStr, "proxy", "", "Proxy URL (http:// or socks5://, optionally with user:pass@) or a file with one proxy URL per line")
+	flag.StringVar(&config.ProxyRotation, "proxy-rotation", ProxyRoundRobin, "How proxies are picked: round-robin (per connection) or sticky (per IP)")
+	flag.IntVar(&config.ProxyMaxFailures, "proxy-max-failures", 3, "Consecutive connection failures after which a proxy is retired")
+	flag.StringVar(&resolversStr, "resolvers", "", "Comma-separated DNS servers (ip or ip:port), used round-robin with failover (default: system resolver)")
+	flag.StringVar(&resolversFile, "resolvers-file", "", "File with one DNS server per line, added to -resolvers")
+	flag.StringVar(&dnsHostsFile, "dns-hosts", "", "File in /etc/hosts format whose addresses override DNS lookups")
+	flag.BoolVar(&config.KeepAlive, "keep-alive", false, "Reuse connections per IP:port instead of closing them after every request")
+	flag.IntVar(&config.MaxConnsPerHost, "max-conns-per-host", 0, "Maximum connections per IP:port with -keep-alive (0 for the default of 512)")
+	flag.IntVar(&config.Pipeline, "pipeline", 0, "Pipeline up to N requests with different Host headers per connection (0 to disable)")
+	flag.StringVar(&config.H2Authority, "h2-authority", H2AuthorityHost, "Where HTTP/2 requests carry the candidate: host (:authority), ip (IP in :authority, candidate in Host) or both")
+	flag.BoolVar(&config.Compressed, "compressed", false, "Send Accept-Encoding: gzip, deflate, br, zstd (compressed responses are always decoded)")
+	flag.BoolVar(&config.AutoCalibrate, "auto-calibrate", false, "Calibrate each IP with random hostnames and suppress responses matching its default vhost")
+	flag.IntVar(&config.CalibrationRequests, "calibration-requests", 3, "Number of random hostnames sent per IP, protocol and path during calibration")
+	flag.StringVar(&portsStr, "ports", "", "Comma-separated list of ports and port ranges, e.g. 80,443,8080-8090 (default: protocol default port)")
+	flag.BoolVar(&config.DetectProtocol, "detect-protocol", false, "Probe each IP:port once for TLS and only use the matching protocol")
+	flag.StringVar(&config.SNIMode, "sni-mode", SNINone, "TLS SNI for HTTPS requests: none, host (candidate hostname) or fixed (value of -sni)")
+	flag.StringVar(&config.SNIName, "sni", "", "Fixed SNI name sent while the Host header varies (implies -sni-mode fixed)")
+	flag.BoolVar(&config.HarvestCerts, "harvest-certs", false, "Collect CN and SAN names from each IP's TLS certificate and add them to the hostnames")
+	flag.StringVar(&config.HarvestOutput, "harvest-output", "", "File to write harvested certificate names to (implies -harvest-certs)")
+	flag.BoolVar(&config.Recursive, "recursive", false, "Test hostnames discovered in matched responses against all IPs")
+	flag.StringVar(&scopeStr, "scope", "", "Comma-separated list of apex domains discovered hostnames must belong to (required with -recursive)")
+	flag.IntVar(&config.RecursiveDepth, "recursive-depth", 2, "Maximum recursion depth for discovered hostnames")
+	flag.StringVar(&config.ResumeFile, "resume", "", "State file to checkpoint progress to and resume an interrupted scan from")
+	flag.BoolVar(&config.HostHeaderPort, "host-header-port", false, "Include non-default ports in the Host header")
+	flag.StringVar(&config.OutputFile, "o", "", "File to write findings to")
+	flag.StringVar(&outputFormatsStr, "of", "jsonl", "Comma-separated list of output formats (jsonl,csv,md,html)")
+	for _, mf := range matchFlags {
+		matchValues[mf.kind] = flag.String(mf.name, "", "Match "+mf.usage)
+		filterValues[mf.kind] = flag.String("f"+mf.name[1:], "", "Filter "+mf.usage)
+	}
+	flag.StringVar(&matchConditionStr, "mc-condition", "and", "How matchers are combined: and (all must match) or or (any must match), filters always drop on any match")
+	flag.StringVar(&exprStr, "expr", "", "Only report responses for which the expression is true, e.g. 'status == 200 && len(body) > 5000'")
+	flag.Var(&extractRegexes, "extract-regex", "Capture regex matches (or the first group) from matched bodies as name=pattern, can be repeated")
+	flag.Var(&extractHeaders, "extract-header", "Capture a response header as Header or name=Header, can be repeated")
+	flag.Var(&extractJSONs, "extract-json", "Capture values from JSON bodies as name=path, e.g. version=data.version or ids=items.*.id, can be repeated")
+	flag.IntVar(&config.SimilarityThreshold, "similarity-threshold", 0, "Only report responses less than N% similar to the IP's default vhost (implies -auto-calibrate, 0 to disable)")
 
 	flag.Parse()
 
@@ -70,6 +285,112 @@ func ParseFlags() Config {
 		}
 	}
 
+	// Parse the match and filter flags, the legacy flags are match rules too
+	if len(config.HTTPStatusIs) > 0 {
+		rule := MatchRule{Kind: MatchStatus}
+		for _, code := range config.HTTPStatusIs {
+			rule.Ranges = append(rule.Ranges, Range{Min: int64(code), Max: int64(code)})
+		}
+		config.MatchRules = append(config.MatchRules, rule)
+	}
+	if config.HTTPBodyIncludes != "" {
+		config.MatchRules = append(config.MatchRules, MatchRule{
+			Kind:    MatchRegex,
+			Pattern: regexp.MustCompile(regexp.QuoteMeta(config.HTTPBodyIncludes)),
+		})
+	}
+	for _, mf := range matchFlags {
+		for _, filter := range []bool{false, true} {
+			name, value := mf.name, *matchValues[mf.kind]
+			if filter {
+				name, value = "f"+mf.name[1:], *filterValues[mf.kind]
+			}
+			if value == "" {
+				continue
+			}
+
+			rule, err := parseMatchRule(mf.kind, value)
+			if err != nil {
+				fmt.Printf("Invalid -%s: %v\n", name, err)
+				os.Exit(1)
+			}
+			rule.Filter = filter
+			config.MatchRules = append(config.MatchRules, rule)
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(matchConditionStr)) {
+	case "and":
+		config.MatchAll = true
+	case "or":
+	default:
+		fmt.Printf("Invalid match condition: %s (must be and or or)\n", matchConditionStr)
+		os.Exit(1)
+	}
+
+	if exprStr != "" {
+		program, err := expr.Compile(exprStr, ExprVars)
+		if err != nil {
+			fmt.Printf("Invalid -expr: %v\n", err)
+			os.Exit(1)
+		}
+		config.Expr = program
+
+		// Baseline values need calibration
+		for name := range ExprVars {
+			if (strings.HasPrefix(name, "baseline_") || name == "similarity") && program.Uses(name) {
+				config.AutoCalibrate = true
+			}
+		}
+	}
+
+	// Parse the extractors
+	for _, value := range extractRegexes {
+		name, pattern, ok := strings.Cut(value, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			fmt.Printf("Invalid -extract-regex: %s (expected name=pattern)\n", value)
+			os.Exit(1)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			fmt.Printf("Invalid -extract-regex %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		config.Extractors = append(config.Extractors, ExtractorSpec{Kind: ExtractRegex, Name: strings.TrimSpace(name), Pattern: re})
+	}
+	for _, value := range extractHeaders {
+		name, header, ok := strings.Cut(value, "=")
+		if !ok {
+			header = name
+		}
+		name, header = strings.TrimSpace(name), strings.TrimSpace(header)
+		if name == "" || header == "" {
+			fmt.Printf("Invalid -extract-header: %s\n", value)
+			os.Exit(1)
+		}
+		config.Extractors = append(config.Extractors, ExtractorSpec{Kind: ExtractHeader, Name: name, Header: header})
+	}
+	for _, value := range extractJSONs {
+		name, path, ok := strings.Cut(value, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !ok || name == "" || path == "" {
+			fmt.Printf("Invalid -extract-json: %s (expected name=path)\n", value)
+			os.Exit(1)
+		}
+		config.Extractors = append(config.Extractors, ExtractorSpec{Kind: ExtractJSON, Name: name, Path: strings.Split(path, ".")})
+	}
+
+	if config.SimilarityThreshold < 0 || config.SimilarityThreshold > 100 {
+		fmt.Printf("Invalid similarity threshold: %d (must be between 0 and 100)\n", config.SimilarityThreshold)
+		os.Exit(1)
+	}
+	if config.SimilarityThreshold > 0 {
+		config.AutoCalibrate = true
+	}
+	if config.CalibrationRequests < 1 {
+		config.CalibrationRequests = 1
+	}
+
 	config.RequestTimeout = time.Duration(requestTimeout) * time.Second
 	config.MaxIdleConnDuration = time.Duration(maxIdleConnDuration) * time.Second
 	config.MaxConnDuration = time.Duration(maxConnDuration) * time.Second
@@ -87,7 +408,8 @@ func ParseFlags() Config {
 	protocols := strings.Split(protocolStr, ",")
 	for _, protocol := range protocols {
 		protocol = strings.ToLower(strings.TrimSpace(protocol))
-		if protocol == "http" || protocol == "https" {
+		switch protocol {
+		case "http", "https", "h2", "h2c":
 			config.Protocols = append(config.Protocols, protocol)
 		}
 	}
@@ -96,5 +418,347 @@ func ParseFlags() Config {
 		config.Protocols = []string{"http"}
 	}
 
+	if config.HarvestOutput != "" {
+		config.HarvestCerts = true
+	}
+
+	for _, apex := range strings.Split(scopeStr, ",") {
+		apex = strings.Trim(strings.ToLower(strings.TrimSpace(apex)), ".")
+		if apex != "" {
+			config.RecursiveScope = append(config.RecursiveScope, apex)
+		}
+	}
+	if config.Recursive && len(config.RecursiveScope) == 0 {
+		fmt.Printf("-recursive requires -scope\n")
+		os.Exit(1)
+	}
+
+	config.SNIMode = strings.ToLower(strings.TrimSpace(config.SNIMode))
+	if config.SNIName != "" && config.SNIMode == SNINone {
+		config.SNIMode = SNIFixed
+	}
+	switch config.SNIMode {
+	case SNINone, SNIHost:
+	case SNIFixed:
+		if config.SNIName == "" {
+			fmt.Printf("-sni-mode fixed requires -sni\n")
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Invalid SNI mode: %s\n", config.SNIMode)
+		os.Exit(1)
+	}
+
+	config.RedirectHost = strings.ToLower(strings.TrimSpace(config.RedirectHost))
+	if config.RedirectHost != RedirectHostLocation && config.RedirectHost != RedirectHostFuzzed {
+		fmt.Printf("Invalid redirect host: %s\n", config.RedirectHost)
+		os.Exit(1)
+	}
+	if config.MaxRedirects < 0 {
+		fmt.Printf("-max-redirects must not be negative\n")
+		os.Exit(1)
+	}
+
+	if proxyStr != "" {
+		proxies, err := parseProxies(proxyStr)
+		if err != nil {
+			fmt.Printf("Invalid proxy: %v\n", err)
+			os.Exit(1)
+		}
+		config.Proxies = proxies
+	}
+	config.ProxyRotation = strings.ToLower(strings.TrimSpace(config.ProxyRotation))
+	if config.ProxyRotation != ProxyRoundRobin && config.ProxyRotation != ProxySticky {
+		fmt.Printf("Invalid proxy rotation: %s\n", config.ProxyRotation)
+		os.Exit(1)
+	}
+	if config.ProxyMaxFailures < 1 {
+		fmt.Printf("-proxy-max-failures must be at least 1\n")
+		os.Exit(1)
+	}
+
+	if resolversStr != "" || resolversFile != "" {
+		var entries []string
+		if resolversStr != "" {
+			entries = strings.Split(resolversStr, ",")
+		}
+		if resolversFile != "" {
+			lines, err := readLines(resolversFile)
+			if err != nil {
+				fmt.Printf("Error reading resolvers file: %v\n", err)
+				os.Exit(1)
+			}
+			entries = append(entries, lines...)
+		}
+		resolvers, err := parseResolvers(entries)
+		if err != nil {
+			fmt.Printf("Invalid resolvers: %v\n", err)
+			os.Exit(1)
+		}
+		config.Resolvers = resolvers
+	}
+	if dnsHostsFile != "" {
+		dnsHosts, err := parseDNSHosts(dnsHostsFile)
+		if err != nil {
+			fmt.Printf("Error reading DNS hosts file: %v\n", err)
+			os.Exit(1)
+		}
+		config.DNSHosts = dnsHosts
+	}
+
+	config.H2Authority = strings.ToLower(strings.TrimSpace(config.H2Authority))
+	switch config.H2Authority {
+	case H2AuthorityHost, H2AuthorityIP, H2AuthorityBoth:
+	default:
+		fmt.Printf("Invalid HTTP/2 authority mode: %s\n", config.H2Authority)
+		os.Exit(1)
+	}
+
+	if config.Pipeline < 0 {
+		fmt.Printf("-pipeline must not be negative\n")
+		os.Exit(1)
+	}
+	if config.MaxConnsPerHost < 0 {
+		fmt.Printf("-max-conns-per-host must not be negative\n")
+		os.Exit(1)
+	}
+
+	ports, err := parsePorts(portsStr)
+	if err != nil {
+		fmt.Printf("Invalid ports: %v\n", err)
+		os.Exit(1)
+	}
+	config.Ports = ports
+
+	// Parse the comma-separated output formats
+	for _, format := range strings.Split(outputFormatsStr, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		switch format {
+		case "":
+			continue
+		case "jsonl", "csv", "md", "html":
+			config.OutputFormats = append(config.OutputFormats, format)
+		default:
+			fmt.Printf("Invalid output format: %s\n", format)
+			os.Exit(1)
+		}
+	}
+	if config.OutputFile != "" && len(config.OutputFormats) == 0 {
+		config.OutputFormats = []string{"jsonl"}
+	}
+
 	return config
 }
+
+// readLines returns the lines of a file, skipping empty lines and lines
+// starting with #
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// parseProxies parses a proxy URL, or the proxy URLs in a file with one per
+// line
+func parseProxies(value string) ([]*url.URL, error) {
+	lines := []string{value}
+	if !strings.Contains(value, "://") {
+		var err error
+		if lines, err = readLines(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var proxies []*url.URL
+	for _, line := range lines {
+		proxy, err := url.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		switch proxy.Scheme {
+		case "http", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("%s: unsupported scheme, use http:// or socks5://", line)
+		}
+		if proxy.Port() == "" {
+			return nil, fmt.Errorf("%s: missing port", line)
+		}
+		proxies = append(proxies, proxy)
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no proxies in %s", value)
+	}
+	return proxies, nil
+}
+
+// parseResolvers parses DNS servers given as ip or ip:port. Servers without a
+// port use port 53.
+func parseResolvers(entries []string) ([]string, error) {
+	var resolvers []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			host, port = strings.Trim(entry, "[]"), "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("%s is not an IP address", entry)
+		}
+		resolvers = append(resolvers, net.JoinHostPort(host, port))
+	}
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("no resolvers given")
+	}
+	return resolvers, nil
+}
+
+// parseDNSHosts reads a file in /etc/hosts format, an address followed by
+// its hostnames on each line
+func parseDNSHosts(path string) (map[string][]net.IP, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string][]net.IP)
+	for _, line := range lines {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid line: %s", line)
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address: %s", fields[0])
+		}
+		for _, name := range fields[1:] {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			hosts[name] = append(hosts[name], ip)
+		}
+	}
+	return hosts, nil
+}
+
+// parsePorts parses a comma-separated list of ports and port ranges
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", part)
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", part)
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range: %s", part)
+		}
+
+		for port := start; port <= end; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// parseMatchRule parses the value of a match or filter flag of the given kind
+func parseMatchRule(kind, value string) (MatchRule, error) {
+	rule := MatchRule{Kind: kind}
+	var err error
+
+	switch kind {
+	case MatchStatus, MatchWords, MatchLines, MatchSize, MatchTime:
+		rule.Ranges, err = parseRanges(value)
+	case MatchRegex, MatchTitle, MatchRedirect:
+		rule.Pattern, err = regexp.Compile(value)
+	case MatchHeader:
+		name, pattern, hasValue := strings.Cut(value, ":")
+		rule.Header = strings.TrimSpace(name)
+		if rule.Header == "" {
+			return rule, fmt.Errorf("missing header name")
+		}
+		if hasValue {
+			rule.Pattern, err = regexp.Compile(strings.TrimSpace(pattern))
+		}
+	case MatchContentType:
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+				rule.Values = append(rule.Values, v)
+			}
+		}
+	}
+	return rule, err
+}
+
+// parseRanges parses a comma-separated list of numbers and ranges in the
+// forms N, N-M, <N and >N
+func parseRanges(s string) ([]Range, error) {
+	var ranges []Range
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var r Range
+		var err error
+		switch {
+		case strings.HasPrefix(part, "<"):
+			r.Max, err = strconv.ParseInt(strings.TrimSpace(part[1:]), 10, 64)
+			r.Max--
+		case strings.HasPrefix(part, ">"):
+			r.Min, err = strconv.ParseInt(strings.TrimSpace(part[1:]), 10, 64)
+			r.Min++
+			r.Max = math.MaxInt64
+		default:
+			from, to, isRange := strings.Cut(part, "-")
+			if !isRange {
+				to = from
+			}
+			r.Min, err = strconv.ParseInt(strings.TrimSpace(from), 10, 64)
+			if err == nil {
+				r.Max, err = strconv.ParseInt(strings.TrimSpace(to), 10, 64)
+			}
+		}
+		if err != nil || r.Min < 0 || r.Min > r.Max {
+			return nil, fmt.Errorf("invalid range: %s", part)
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no values given")
+	}
+	return ranges, nil
+}
@@ -0,0 +1,304 @@
+// Package expr implements a small expression language used to decide which
+// responses are reported, e.g.
+//
+//	status == 200 && len(body) > 5000 && !contains(title, "Default")
+//
+// Expressions are type checked when compiled. Values are bools, numbers
+// (float64), strings and string maps.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Type is the type of a value
+type Type int
+
+const (
+	Bool Type = iota
+	Number
+	String
+	Map
+)
+
+func (t Type) String() string {
+	switch t {
+	case Bool:
+		return "bool"
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case Map:
+		return "map"
+	}
+	return "unknown"
+}
+
+// StringMap is the value of a Map variable, e.g. case-insensitive headers
+type StringMap interface {
+	Get(key string) string
+}
+
+// Env supplies variable values when a program is evaluated. Lookup must return
+// a bool, float64, string or StringMap matching the declared type.
+type Env interface {
+	Lookup(name string) interface{}
+}
+
+// Program is a compiled expression
+type Program struct {
+	src  string
+	root node
+	used map[string]bool
+}
+
+// Compile parses src, which may only use the declared variables and must
+// evaluate to a bool
+func Compile(src string, vars map[string]Type) (*Program, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, vars: vars, used: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("%d: unexpected %s", t.pos, t)
+	}
+	if root.typ() != Bool {
+		return nil, fmt.Errorf("expression must be a bool, got %s", root.typ())
+	}
+
+	return &Program{src: src, root: root, used: p.used}, nil
+}
+
+// Eval evaluates the program. Variables are only looked up when needed.
+func (p *Program) Eval(env Env) bool {
+	return p.root.eval(env).(bool)
+}
+
+// Uses reports whether the program refers to the variable
+func (p *Program) Uses(name string) bool {
+	return p.used[name]
+}
+
+func (p *Program) String() string {
+	return p.src
+}
+
+type node interface {
+	typ() Type
+	eval(env Env) interface{}
+}
+
+type literal struct {
+	t     Type
+	value interface{}
+}
+
+func (n *literal) typ() Type            { return n.t }
+func (n *literal) eval(Env) interface{} { return n.value }
+
+type variable struct {
+	name string
+	t    Type
+}
+
+func (n *variable) typ() Type                { return n.t }
+func (n *variable) eval(env Env) interface{} { return env.Lookup(n.name) }
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) typ() Type                { return Bool }
+func (n *notNode) eval(env Env) interface{} { return !n.operand.eval(env).(bool) }
+
+type negNode struct {
+	operand node
+}
+
+func (n *negNode) typ() Type                { return Number }
+func (n *negNode) eval(env Env) interface{} { return -n.operand.eval(env).(float64) }
+
+type indexNode struct {
+	m, key node
+}
+
+func (n *indexNode) typ() Type { return String }
+func (n *indexNode) eval(env Env) interface{} {
+	return n.m.eval(env).(StringMap).Get(n.key.eval(env).(string))
+}
+
+// logicalNode short-circuits && and ||
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func newLogical(op token, left, right node) (node, error) {
+	if left.typ() != Bool || right.typ() != Bool {
+		return nil, fmt.Errorf("%d: %s needs bools, got %s and %s", op.pos, op.text, left.typ(), right.typ())
+	}
+	return &logicalNode{and: op.text == "&&", left: left, right: right}, nil
+}
+
+func (n *logicalNode) typ() Type { return Bool }
+func (n *logicalNode) eval(env Env) interface{} {
+	if n.left.eval(env).(bool) != n.and {
+		return !n.and
+	}
+	return n.right.eval(env).(bool)
+}
+
+type comparisonNode struct {
+	op          string
+	left, right node
+}
+
+func newComparison(op token, left, right node) (node, error) {
+	if left.typ() != right.typ() {
+		return nil, fmt.Errorf("%d: cannot compare %s with %s", op.pos, left.typ(), right.typ())
+	}
+	ordering := op.text != "==" && op.text != "!="
+	if left.typ() == Map || (ordering && left.typ() == Bool) {
+		return nil, fmt.Errorf("%d: cannot use %s on %s values", op.pos, op.text, left.typ())
+	}
+	return &comparisonNode{op: op.text, left: left, right: right}, nil
+}
+
+func (n *comparisonNode) typ() Type { return Bool }
+func (n *comparisonNode) eval(env Env) interface{} {
+	left, right := n.left.eval(env), n.right.eval(env)
+
+	var cmp int
+	switch l := left.(type) {
+	case bool:
+		if l != right.(bool) {
+			cmp = 1
+		}
+	case float64:
+		r := right.(float64)
+		if l < r {
+			cmp = -1
+		} else if l > r {
+			cmp = 1
+		}
+	case string:
+		cmp = strings.Compare(l, right.(string))
+	}
+
+	switch n.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// arithmeticNode handles number arithmetic and string concatenation with +
+type arithmeticNode struct {
+	op          string
+	t           Type
+	left, right node
+}
+
+func newArithmetic(op token, left, right node) (node, error) {
+	if left.typ() == String && right.typ() == String && op.text == "+" {
+		return &arithmeticNode{op: op.text, t: String, left: left, right: right}, nil
+	}
+	if left.typ() != Number || right.typ() != Number {
+		return nil, fmt.Errorf("%d: %s needs numbers, got %s and %s", op.pos, op.text, left.typ(), right.typ())
+	}
+	return &arithmeticNode{op: op.text, t: Number, left: left, right: right}, nil
+}
+
+func (n *arithmeticNode) typ() Type { return n.t }
+func (n *arithmeticNode) eval(env Env) interface{} {
+	if n.t == String {
+		return n.left.eval(env).(string) + n.right.eval(env).(string)
+	}
+
+	l, r := n.left.eval(env).(float64), n.right.eval(env).(float64)
+	switch n.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		return l / r
+	}
+	return math.Mod(l, r)
+}
+
+type function struct {
+	args   []Type
+	result Type
+	call   func(args []interface{}) interface{}
+}
+
+// functions are the built-in functions. matches is special cased by the
+// parser, which compiles its pattern.
+var functions = map[string]function{
+	"len": {[]Type{String}, Number, func(args []interface{}) interface{} {
+		return float64(len(args[0].(string)))
+	}},
+	"contains": {[]Type{String, String}, Bool, func(args []interface{}) interface{} {
+		return strings.Contains(args[0].(string), args[1].(string))
+	}},
+	"icontains": {[]Type{String, String}, Bool, func(args []interface{}) interface{} {
+		return strings.Contains(strings.ToLower(args[0].(string)), strings.ToLower(args[1].(string)))
+	}},
+	"startsWith": {[]Type{String, String}, Bool, func(args []interface{}) interface{} {
+		return strings.HasPrefix(args[0].(string), args[1].(string))
+	}},
+	"endsWith": {[]Type{String, String}, Bool, func(args []interface{}) interface{} {
+		return strings.HasSuffix(args[0].(string), args[1].(string))
+	}},
+	"lower": {[]Type{String}, String, func(args []interface{}) interface{} {
+		return strings.ToLower(args[0].(string))
+	}},
+	"upper": {[]Type{String}, String, func(args []interface{}) interface{} {
+		return strings.ToUpper(args[0].(string))
+	}},
+	"abs": {[]Type{Number}, Number, func(args []interface{}) interface{} {
+		return math.Abs(args[0].(float64))
+	}},
+	"matches": {[]Type{String, String}, Bool, nil},
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+	re   *regexp.Regexp // Compiled pattern of matches
+}
+
+func (n *callNode) typ() Type { return n.fn.result }
+func (n *callNode) eval(env Env) interface{} {
+	if n.re != nil {
+		return n.re.MatchString(n.args[0].eval(env).(string))
+	}
+
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(env)
+	}
+	return n.fn.call(args)
+}
@@ -0,0 +1,201 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+var testVars = map[string]Type{
+	"status":   Number,
+	"time":     Number,
+	"body":     String,
+	"title":    String,
+	"headers":  Map,
+	"redirect": Bool,
+}
+
+// testEnv panics on variables it does not hold, so tests notice lookups
+// that short-circuiting should have skipped
+type testEnv map[string]interface{}
+
+func (e testEnv) Lookup(name string) interface{} {
+	value, ok := e[name]
+	if !ok {
+		panic("unexpected lookup of " + name)
+	}
+	return value
+}
+
+// testHeaders is a case-insensitive StringMap
+type testHeaders map[string]string
+
+func (h testHeaders) Get(key string) string {
+	return h[strings.ToLower(key)]
+}
+
+func newTestEnv() testEnv {
+	return testEnv{
+		"status":   float64(200),
+		"time":     float64(1500),
+		"body":     "<html><title>Admin Panel</title>id=42</html>",
+		"title":    "Admin Panel",
+		"headers":  testHeaders{"content-type": "text/html", "server": "nginx"},
+		"redirect": false,
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		// Precedence and associativity
+		{"multiplication before addition", "1 + 2 * 3 == 7", true},
+		{"parentheses", "(1 + 2) * 3 == 9", true},
+		{"subtraction is left associative", "10 - 4 - 3 == 3", true},
+		{"division is left associative", "8 / 4 / 2 == 1", true},
+		{"modulo", "7 % 4 == 3", true},
+		{"unary minus binds tighter than *", "-2 * 3 == -6", true},
+		{"double negation", "--2 == 2", true},
+		{"&& before ||", "true || false && false", true},
+		{"&& before || on the left", "false && true || true", true},
+		{"! before &&", "!false && false", false},
+		{"! on parentheses", "!(status == 200)", false},
+		{"comparison before &&", "status >= 200 && status < 300", true},
+		{"arithmetic before comparison", "time / 1000 > 1", true},
+
+		// Comparisons
+		{"number equality", "status == 200", true},
+		{"number inequality", "status != 200", false},
+		{"less or equal", "status <= 200", true},
+		{"greater", "status > 200", false},
+		{"decimal numbers", "1.5 * 2 == 3", true},
+		{"leading dot", ".5 + .5 == 1", true},
+		{"string equality", `title == "Admin Panel"`, true},
+		{"string ordering", `"abc" < "abd"`, true},
+		{"bool equality", "redirect == false", true},
+		{"bool inequality", "redirect != true", true},
+
+		// Strings
+		{"concatenation", `"Admin" + " " + "Panel" == title`, true},
+		{"escapes", `"a\"b\n" == "a" + "\"" + "b" + "\n"`, true},
+		{"raw string", "`a\\nb` == \"a\\\\nb\"", true},
+		{"raw string with quotes", "`say \"hi\"` == \"say \\\"hi\\\"\"", true},
+
+		// Maps
+		{"header", `headers["content-type"] == "text/html"`, true},
+		{"header case-insensitive", `headers["Server"] == "nginx"`, true},
+		{"missing header", `headers["x"] == ""`, true},
+		{"computed key", `headers["ser" + "ver"] == "nginx"`, true},
+
+		// Functions
+		{"len", "len(title) == 11", true},
+		{"len of empty string", `len("") == 0`, true},
+		{"contains", `contains(body, "id=42")`, true},
+		{"contains is case-sensitive", `contains(title, "admin")`, false},
+		{"icontains", `icontains(title, "ADMIN")`, true},
+		{"startsWith", `startsWith(body, "<html>")`, true},
+		{"startsWith false", `startsWith(title, "Panel")`, false},
+		{"endsWith", `endsWith(title, "Panel")`, true},
+		{"endsWith false", `endsWith(title, "Admin")`, false},
+		{"lower", `lower(title) == "admin panel"`, true},
+		{"upper", `upper(headers["server"]) == "NGINX"`, true},
+		{"abs", "abs(-3) == 3 && abs(2) == 2", true},
+		{"matches", "matches(body, `id=\\d+`)", true},
+		{"matches false", `matches(title, "^Panel")`, false},
+		{"nested calls", `len(lower(title + "!")) == 12`, true},
+
+		// Short-circuiting skips variables the env does not hold
+		{"&& short-circuits", "false && missing", false},
+		{"|| short-circuits", "true || missing", true},
+	}
+
+	vars := map[string]Type{"missing": Bool}
+	for name, typ := range testVars {
+		vars[name] = typ
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.src, vars)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.src, err)
+			}
+			if got := p.Eval(newTestEnv()); got != tt.want {
+				t.Errorf("Eval(%q) = %v, want %v", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Typing
+		{`status == "200"`, "8: cannot compare number with string"},
+		{`title == 1`, "cannot compare string with number"},
+		{`headers["server"] == 1`, "cannot compare string with number"},
+		{`title + 1 == 1`, "+ needs numbers, got string and number"},
+		{`title - "a" == ""`, "- needs numbers, got string and string"},
+		{`-title == ""`, "- needs a number, got string"},
+		{`!status`, "! needs a bool, got number"},
+		{`status && true`, "&& needs bools, got number and bool"},
+		{`title || true`, "|| needs bools, got string and bool"},
+		{`true < false`, "cannot use < on bool values"},
+		{`headers == headers`, "cannot use == on map values"},
+		{`status`, "expression must be a bool, got number"},
+		{`title + "x"`, "expression must be a bool, got string"},
+		{`status["x"] == ""`, "only maps can be indexed, with a string"},
+		{`headers[1] == ""`, "only maps can be indexed, with a string"},
+
+		// Functions
+		{`len(status) == 1`, "argument 1 of len must be a string, got number"},
+		{`abs(title) == 1`, "argument 1 of abs must be a number, got string"},
+		{`contains(title)`, "contains takes 2 arguments, got 1"},
+		{`lower() == ""`, "lower takes 1 arguments, got 0"},
+		{`nope(title)`, "unknown function nope"},
+		{`matches(body, title)`, "the pattern of matches must be a string literal"},
+		{`matches(body, "(")`, "invalid pattern"},
+
+		// Syntax
+		{`unknown == 1`, "1: unknown variable unknown"},
+		{`title == "abc`, "10: unterminated string"},
+		{"title == `abc", "unterminated string"},
+		{`title == "\q"`, "invalid string"},
+		{`status @ 1`, "8: unexpected character '@'"},
+		{`1..2 == 1`, "invalid number 1..2"},
+		{`status == 200 &&`, "unexpected end of expression"},
+		{`status == 200)`, `14: unexpected ")"`},
+		{`(status == 200`, `expected ")", got end of expression`},
+		{`headers["x" == ""`, `expected "]"`},
+		{`status == 200 == true`, `unexpected "=="`},
+		{``, "unexpected end of expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Compile(tt.src, testVars)
+			if err == nil {
+				t.Fatalf("Compile(%q) succeeded, want error containing %q", tt.src, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Compile(%q) error = %q, want it to contain %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUses(t *testing.T) {
+	p, err := Compile(`status == 200 && contains(headers["server"], "nginx")`, testVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range testVars {
+		want := name == "status" || name == "headers"
+		if got := p.Uses(name); got != want {
+			t.Errorf("Uses(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
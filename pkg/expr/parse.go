@@ -0,0 +1,365 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // 1-based column
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators are matched longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ","}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], start + 1})
+
+		case c == '"' || c == '`':
+			// Strings follow the Go rules, backquoted strings are raw
+			start := i
+			for i++; i < len(src) && src[i] != c; i++ {
+				if c == '"' && src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("%d: unterminated string", start+1)
+			}
+			i++
+			value, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("%d: invalid string %s", start+1, src[start:i])
+			}
+			tokens = append(tokens, token{tokString, value, start + 1})
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], start + 1})
+
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%d: unexpected character %q", i+1, c)
+			}
+			tokens = append(tokens, token{tokOp, op, i + 1})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(src) + 1}), nil
+}
+
+// parser is a recursive descent parser that type checks while parsing.
+// Precedence from low to high: ||, &&, comparisons, + -, * / %, unary ! -,
+// indexing.
+type parser struct {
+	tokens []token
+	pos    int
+	vars   map[string]Type
+	used   map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators
+func (p *parser) accept(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tokOp {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return p.next(), true
+		}
+	}
+	return t, false
+}
+
+func (p *parser) expect(op string) error {
+	if t, ok := p.accept(op); !ok {
+		return fmt.Errorf("%d: expected %q, got %s", t.pos, op, t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newLogical(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return newComparison(op, left, right)
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newArithmetic(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = newArithmetic(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	op, ok := p.accept("!", "-")
+	if !ok {
+		return p.parsePostfix()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op.text == "!" {
+		if operand.typ() != Bool {
+			return nil, fmt.Errorf("%d: ! needs a bool, got %s", op.pos, operand.typ())
+		}
+		return &notNode{operand}, nil
+	}
+	if operand.typ() != Number {
+		return nil, fmt.Errorf("%d: - needs a number, got %s", op.pos, operand.typ())
+	}
+	return &negNode{operand}, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		open, ok := p.accept("[")
+		if !ok {
+			return n, nil
+		}
+		key, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		if n.typ() != Map || key.typ() != String {
+			return nil, fmt.Errorf("%d: only maps can be indexed, with a string", open.pos)
+		}
+		n = &indexNode{n, key}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%d: invalid number %s", t.pos, t.text)
+		}
+		return &literal{Number, value}, nil
+
+	case tokString:
+		return &literal{String, t.text}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &literal{Bool, t.text == "true"}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		typ, ok := p.vars[t.text]
+		if !ok {
+			return nil, fmt.Errorf("%d: unknown variable %s", t.pos, t.text)
+		}
+		p.used[t.text] = true
+		return &variable{t.text, typ}, nil
+
+	case tokOp:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("%d: unexpected %s", t.pos, t)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("%d: unknown function %s", name.pos, name.text)
+	}
+
+	var args []node
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) != len(fn.args) {
+		return nil, fmt.Errorf("%d: %s takes %d arguments, got %d", name.pos, name.text, len(fn.args), len(args))
+	}
+	for i, arg := range args {
+		if arg.typ() != fn.args[i] {
+			return nil, fmt.Errorf("%d: argument %d of %s must be a %s, got %s", name.pos, i+1, name.text, fn.args[i], arg.typ())
+		}
+	}
+
+	call := &callNode{name: name.text, fn: fn, args: args}
+	if name.text == "matches" {
+		// The pattern must be a literal, so it is compiled once and checked now
+		pattern, ok := args[1].(*literal)
+		if !ok {
+			return nil, fmt.Errorf("%d: the pattern of matches must be a string literal", name.pos)
+		}
+		re, err := regexp.Compile(pattern.value.(string))
+		if err != nil {
+			return nil, fmt.Errorf("%d: invalid pattern: %v", name.pos, err)
+		}
+		call.re = re
+	}
+	return call, nil
+}
@@ -0,0 +1,259 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// responseFingerprint is a compact summary of a response used to compare
+// candidate vhosts against the default vhost of an IP.
+type responseFingerprint struct {
+	StatusCode int
+	Length     int
+	Words      int
+	Lines      int
+	MaskedLen  int // Length with the Host value removed
+	BodyHash   uint64
+	SimHash    uint64
+}
+
+// fingerprintResponse summarizes a response to a request sent with the given
+// Host value, which is masked out of the similarity hash.
+func fingerprintResponse(statusCode int, body []byte, host string) responseFingerprint {
+	h := fnv.New64a()
+	h.Write(body)
+
+	return responseFingerprint{
+		StatusCode: statusCode,
+		Length:     len(body),
+		Words:      countWords(body),
+		Lines:      countLines(body),
+		MaskedLen:  maskedLength(body, host),
+		BodyHash:   h.Sum64(),
+		SimHash:    simhash(body, host),
+	}
+}
+
+// maskedLength returns the length of body without the occurrences of host,
+// which is equal for pages that only differ by reflecting the Host header.
+// The host is searched case-insensitively in body itself, lowering a copy
+// can change the length of non-ASCII bodies.
+func maskedLength(body []byte, host string) int {
+	if host == "" {
+		return len(body)
+	}
+	needle := []byte(strings.ToLower(host))
+	masked := len(body)
+	for i := 0; i+len(needle) <= len(body); {
+		if lowerASCII(body[i]) == needle[0] && bytes.EqualFold(body[i:i+len(needle)], needle) {
+			masked -= len(needle)
+			i += len(needle)
+			continue
+		}
+		i++
+	}
+	return masked
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func countWords(body []byte) int {
+	return len(bytes.Fields(body))
+}
+
+func countLines(body []byte) int {
+	if len(body) == 0 {
+		return 0
+	}
+	return bytes.Count(body, []byte("\n")) + 1
+}
+
+// baselineCache holds the calibration samples per IP, protocol and path.
+// Baselines are written once per IP chunk by the batch processor, read
+// concurrently by all workers and removed once the chunk is finished.
+type baselineCache struct {
+	baselines map[string][]responseFingerprint
+	refs      map[string]int // IP chunks using a baseline, an IP may repeat in later chunks
+	mu        sync.RWMutex
+}
+
+func newBaselineCache() *baselineCache {
+	return &baselineCache{
+		baselines: make(map[string][]responseFingerprint),
+		refs:      make(map[string]int),
+	}
+}
+
+func baselineKey(target Target, protocol string) string {
+	return protocol + "://" + target.address(protocol) + target.Path
+}
+
+func (bc *baselineCache) set(key string, samples []responseFingerprint) {
+	bc.mu.Lock()
+	bc.baselines[key] = samples
+	bc.refs[key]++
+	bc.mu.Unlock()
+}
+
+// release drops a reference to the baseline of key, removing it once no IP
+// chunk uses it
+func (bc *baselineCache) release(key string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if _, ok := bc.refs[key]; !ok {
+		return
+	}
+	if bc.refs[key]--; bc.refs[key] == 0 {
+		delete(bc.refs, key)
+		delete(bc.baselines, key)
+	}
+}
+
+func (bc *baselineCache) get(key string) []responseFingerprint {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.baselines[key]
+}
+
+// baselineMaxDistance is the simhash distance up to which a response with the
+// word and line counts of a calibration sample counts as the same page
+const baselineMaxDistance = 3
+
+// matches reports whether fp looks like one of the calibration samples,
+// i.e. the response is most likely served by the catch-all default vhost.
+func (bc *baselineCache) matches(key string, fp responseFingerprint) bool {
+	for _, sample := range bc.get(key) {
+		if sample.StatusCode != fp.StatusCode {
+			continue
+		}
+		if sample.BodyHash == fp.BodyHash {
+			return true
+		}
+		if sample.Words != fp.Words || sample.Lines != fp.Lines {
+			continue
+		}
+		// Pages reflecting the Host header only differ by the reflected
+		// value, pages with dynamic parts hash alike
+		if sample.MaskedLen == fp.MaskedLen || bits.OnesCount64(sample.SimHash^fp.SimHash) <= baselineMaxDistance {
+			return true
+		}
+	}
+	return false
+}
+
+// similarity returns the highest similarity percentage between fp and the
+// calibration samples, or -1 if there is no baseline for key.
+func (bc *baselineCache) similarity(key string, fp responseFingerprint) int {
+	samples := bc.get(key)
+	if len(samples) == 0 {
+		return -1
+	}
+
+	best := 0
+	for _, sample := range samples {
+		score := 0
+		if (sample.Length == 0) == (fp.Length == 0) {
+			score = similarityPercent(sample.SimHash, fp.SimHash)
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// randomHostname returns a hostname that should not exist on any server
+func randomHostname() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf) + ".vhost-fuzzer.invalid"
+}
+
+// calibrateIPs computes the baselines for a chunk of IP:port endpoints before
+// any of their targets are handed to the workers.
+func (s *Scanner) calibrateIPs(ctx context.Context, endpoints []endpoint) {
+	sem := make(chan struct{}, s.config.Concurrency)
+	var wg sync.WaitGroup
+
+	for _, ep := range endpoints {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ep endpoint) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.calibrateIP(ctx, ep)
+		}(ep)
+	}
+
+	wg.Wait()
+}
+
+// releaseBaselines removes the baselines of an IP chunk once all its targets
+// are finished
+func (s *Scanner) releaseBaselines(endpoints []endpoint) {
+	for _, ep := range endpoints {
+		for _, protocol := range s.protocolsFor(Target{IP: ep.IP, Port: ep.Port}) {
+			for _, path := range s.config.Paths {
+				s.baselines.release(baselineKey(Target{IP: ep.IP, Port: ep.Port, Path: path}, protocol))
+			}
+		}
+	}
+}
+
+func (s *Scanner) calibrateIP(ctx context.Context, ep endpoint) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer func() {
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}()
+
+	for _, protocol := range s.protocolsFor(Target{IP: ep.IP, Port: ep.Port}) {
+		for _, path := range s.config.Paths {
+			var samples []responseFingerprint
+			for i := 0; i < s.config.CalibrationRequests && ctx.Err() == nil; i++ {
+				if s.rateLimiter != nil {
+					if err := s.rateLimiter.Wait(ctx); err != nil {
+						break
+					}
+				}
+
+				target := Target{IP: ep.IP, Port: ep.Port, Hostname: randomHostname(), Path: path}
+				if _, err := s.fetch(protocol, target, req, resp); err != nil {
+					continue
+				}
+				samples = append(samples, fingerprintResponse(resp.StatusCode(), resp.Body(), target.Hostname))
+			}
+
+			if len(samples) == 0 {
+				continue
+			}
+			target := Target{IP: ep.IP, Port: ep.Port, Path: path}
+			s.baselines.set(baselineKey(target, protocol), samples)
+
+			if s.config.Verbose {
+				fmt.Printf("[*] Calibrated %s with %d samples (status %d, %d bytes)\n",
+					target.url(protocol), len(samples), samples[0].StatusCode, samples[0].Length)
+			}
+		}
+	}
+}
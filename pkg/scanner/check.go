@@ -2,8 +2,12 @@ package scanner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/valyala/fasthttp"
 )
@@ -25,154 +29,248 @@ const (
 	clearLine      = "\033[2K"   // Clear the current line
 )
 
-func (s *Scanner) checkTarget(target Target, req *fasthttp.Request, resp *fasthttp.Response) string {
+// checkTarget requests target with every protocol and returns the matching
+// results. An error is returned if ctx is cancelled before the target is done.
+func (s *Scanner) checkTarget(ctx context.Context, target Target, req *fasthttp.Request, resp *fasthttp.Response) ([]Result, error) {
 	// Enforce rate limiting
 	if s.rateLimiter != nil {
-		err := s.rateLimiter.Wait(context.Background())
-		if err != nil {
-			if s.config.Verbose {
-				fmt.Printf("\n=== Rate Limit Error ===\n")
-				fmt.Printf("Rate limit exceeded for %s: %v\n", target.IP, err)
-				fmt.Printf("========================\n")
-			}
-			return ""
+		if err := s.rateLimiter.Wait(ctx); err != nil {
+			return nil, err
 		}
 	}
 
-	req.Reset()
-	resp.Reset()
-
-	var results []string
+	var results []Result
 
-	for _, protocol := range s.config.Protocols {
-		reqURI := fmt.Sprintf("%s://%s%s", protocol, target.IP, target.Path)
-		req.SetRequestURI(reqURI)
-		req.SetHost(target.Hostname)
-		req.Header.SetUserAgent("Mozilla/5.0 (X11; Linux x86_64)")
-		req.Header.Set("X-Bug-Bounty", "h1-damian89-test")
-		req.Header.Set("Connection", "close") // Force the server to close the connection
+	for _, protocol := range s.protocolsFor(target) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		hc := s.clients.getClient(target.IP, s.config)
-		err := hc.DoTimeout(req, resp, s.config.RequestTimeout)
+		startTime := time.Now()
+		info, err := s.fetch(protocol, target, req, resp)
+		elapsed := time.Since(startTime)
+		s.requests.Add(1)
 		if err != nil {
-			if s.config.Verbose {
-				fmt.Printf("\n=== Error ===\n")
-				fmt.Printf("Failed to execute request to %s: %v\n", reqURI, err)
-				fmt.Printf("========================\n")
-			}
+			s.requestErrors.Add(1)
 			continue
 		}
 
-		statusCode := resp.StatusCode()
-		contentLength := resp.Header.Peek("Content-Length")
-		body := resp.Body()
-		title := extractTitle(body)
-
-		// Handle redirects manually
-		if s.config.FollowRedirects && (statusCode == 301 || statusCode == 302 || statusCode == 307 || statusCode == 308) {
-			location := resp.Header.Peek("Location")
-			if len(location) > 0 {
-				redirectURI := string(location)
-				if s.config.Verbose {
-					fmt.Printf("[*] Following redirect to: %s\n", redirectURI)
-				}
-				req.SetRequestURI(redirectURI)
-				err := hc.DoTimeout(req, resp, s.config.RequestTimeout)
-				if err != nil {
-					if s.config.Verbose {
-						fmt.Printf("\n=== Redirect Error ===\n")
-						fmt.Printf("Failed to follow redirect to %s: %v\n", redirectURI, err)
-						fmt.Printf("========================\n")
-					}
-					continue
-				}
-				statusCode = resp.StatusCode()
-				contentLength = resp.Header.Peek("Content-Length")
-				body = resp.Body()
-				title = extractTitle(body)
-			}
+		if result, ok := s.checkResponse(target, protocol, info, req, resp, elapsed); ok {
+			results = append(results, result)
 		}
+	}
 
-		if s.config.Verbose {
-			fmt.Printf("\n=== Request ===\n")
-			fmt.Printf("URI: %s\n", reqURI)
-			fmt.Printf("Host: %s\n", target.Hostname)
-			fmt.Printf("Method: %s\n", string(req.Header.Method()))
-			req.Header.VisitAll(func(k, v []byte) {
-				fmt.Printf("%s: %s\n", string(k), string(v))
-			})
-
-			fmt.Printf("\n=== Response ===\n")
-			fmt.Printf("Status: %d\n", statusCode)
-			fmt.Printf("Content-Length: %s\n", contentLength)
-			fmt.Printf("Title: %s\n", title)
-			resp.Header.VisitAll(func(k, v []byte) {
-				fmt.Printf("%s: %s\n", string(k), string(v))
-			})
-			if len(body) > 0 {
-				fmt.Printf("\nBody (truncated):\n%s\n", truncateString(string(body), 1000))
-			}
-			fmt.Printf("========================\n")
+	return results, nil
+}
+
+// checkResponse runs the matchers, baselines and expression on the response
+// to target and returns its result if it is reported
+func (s *Scanner) checkResponse(target Target, protocol string, info fetchInfo, req *fasthttp.Request, resp *fasthttp.Response, elapsed time.Duration) (Result, bool) {
+	statusCode := resp.StatusCode()
+	contentLength := resp.Header.Peek("Content-Length")
+	body := resp.Body()
+	meta := extractMetadata(body, string(resp.Header.ContentType()))
+	title := meta.Title
+
+	if s.config.Verbose {
+		fmt.Printf("\n=== Request ===\n")
+		fmt.Printf("URI: %s\n", info.URI)
+		fmt.Printf("Host: %s\n", target.Hostname)
+		fmt.Printf("Method: %s\n", string(req.Header.Method()))
+		req.Header.VisitAll(func(k, v []byte) {
+			fmt.Printf("%s: %s\n", string(k), string(v))
+		})
+
+		fmt.Printf("\n=== Response ===\n")
+		fmt.Printf("Status: %d\n", statusCode)
+		fmt.Printf("Content-Length: %s\n", contentLength)
+		fmt.Printf("Body length: %d (%d received)\n", len(body), info.WireLength)
+		fmt.Printf("Title: %s\n", title)
+		resp.Header.VisitAll(func(k, v []byte) {
+			fmt.Printf("%s: %s\n", string(k), string(v))
+		})
+		if len(body) > 0 {
+			fmt.Printf("\nBody (truncated):\n%s\n", truncateString(string(body), 1000))
 		}
+		fmt.Printf("========================\n")
+	}
 
-		// Check if the status code is in the list of expected status codes
-		if len(s.config.HTTPStatusIs) > 0 {
-			found := false
-			for _, code := range s.config.HTTPStatusIs {
-				if statusCode == code {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
+	response := newResponse(resp, info, title, elapsed)
+	if !s.matchers.accept(response) {
+		return Result{}, false
+	}
+
+	// Skip responses that look like the IP's default vhost
+	similarity := -1
+	var baseline *responseFingerprint
+	if s.baselines != nil {
+		key := baselineKey(target, protocol)
+		fp := fingerprintResponse(statusCode, body, target.Hostname)
+		if s.baselines.matches(key, fp) {
+			return Result{}, false
+		}
+		similarity = s.baselines.similarity(key, fp)
+		if s.config.SimilarityThreshold > 0 && similarity >= s.config.SimilarityThreshold {
+			return Result{}, false
+		}
+		if samples := s.baselines.get(key); len(samples) > 0 {
+			baseline = &samples[0]
+		}
+	}
+
+	if s.config.Expr != nil {
+		env := &responseEnv{target: target, protocol: protocol, resp: response, baseline: baseline, similarity: similarity}
+		if !s.config.Expr.Eval(env) {
+			return Result{}, false
+		}
+	}
+
+	result := newResult(target, protocol, info, resp, elapsed, meta)
+	result.SNI = s.sniName(target, protocol)
+	result.Depth = target.Depth
+	result.Words = response.Words()
+	result.Lines = response.Lines()
+	result.Extracted = extractValues(s.extractors, response)
+	if s.discovered != nil {
+		s.discoverHosts(target, resp)
+	}
+	if similarity >= 0 {
+		result.Similarity = &similarity
+	}
+	return result, true
+}
+
+// fetchInfo describes how the response of a target was obtained
+type fetchInfo struct {
+	URI          string        // Request URI of the target
+	WireLength   int           // Body length as received, before decoding
+	Encoding     string        // Content-Encoding the body was decoded from
+	Redirects    []RedirectHop // Redirect responses on the way, see fetch
+	Location     string        // Resolved Location if the target redirects
+	RedirectType string        // Class of that redirect
+}
+
+// fetch sends the request for target using protocol and follows redirects if
+// configured. The response is left in resp, with its body decoded.
+func (s *Scanner) fetch(protocol string, target Target, req *fasthttp.Request, resp *fasthttp.Response) (fetchInfo, error) {
+	if err := s.send(protocol, target, req, resp); err != nil {
+		return fetchInfo{URI: target.url(protocol)}, err
+	}
+	return s.finishFetch(protocol, target, req, resp), nil
+}
+
+// finishFetch completes fetch once the response to target is in resp
+func (s *Scanner) finishFetch(protocol string, target Target, req *fasthttp.Request, resp *fasthttp.Response) fetchInfo {
+	info := fetchInfo{URI: target.url(protocol)}
+
+	// Where the target redirects to is reported whether or not it is followed
+	if isRedirect(resp.StatusCode()) {
+		if location := resp.Header.Peek("Location"); len(location) > 0 {
+			info.Location, info.RedirectType = classifyRedirect(target, protocol, string(location))
 		}
+	}
 
-		if s.config.HTTPBodyIncludes != "" {
-			if !strings.Contains(string(body), s.config.HTTPBodyIncludes) {
-				continue
+	// Follow redirects manually, so every hop can be recorded. A hop that fails
+	// leaves the last redirect as the response.
+	for s.config.FollowRedirects && isRedirect(resp.StatusCode()) {
+		location := resp.Header.Peek("Location")
+		if len(location) == 0 {
+			break
+		}
+		next, nextProtocol, redirectURI, ok := s.redirectTarget(target, protocol, string(location))
+		info.Redirects = append(info.Redirects, RedirectHop{Status: resp.StatusCode(), Location: redirectURI})
+		if !ok || len(info.Redirects) > s.config.MaxRedirects {
+			if s.config.Verbose {
+				fmt.Printf("[*] Not following redirect to: %s\n", redirectURI)
 			}
+			break
+		}
+		if s.config.Verbose {
+			fmt.Printf("[*] Following redirect to: %s\n", redirectURI)
 		}
 
-		// Decorate the output with colors and bold text
-		results = append(results, fmt.Sprintf(
-			"\n %s[+] Found match - IP: %s%s, Host: %s%s, Path: %s%s, Status: %s%d%s, Content-Length: %s%s%s, Title: %s%s%s",
-			boldText+colorGreen,
-			colorCyan, target.IP,
-			colorYellow, target.Hostname,
-			colorPurple, target.Path,
-			colorBlue, statusCode, colorReset,
-			colorRed, contentLength, colorReset,
-			colorWhite, title, colorReset,
-		))
+		hop := fasthttp.AcquireResponse()
+		err := s.send(nextProtocol, next, req, hop)
+		if err == nil {
+			hop.CopyTo(resp)
+			target, protocol = next, nextProtocol
+		}
+		fasthttp.ReleaseResponse(hop)
+		if err != nil {
+			break
+		}
 	}
 
-	if len(results) > 0 {
-		return strings.Join(results, "\n")
+	info.WireLength, info.Encoding = s.decodeResponse(resp)
+	return info
+}
+
+// send performs a single request for target using protocol
+func (s *Scanner) send(protocol string, target Target, req *fasthttp.Request, resp *fasthttp.Response) error {
+	s.prepareRequest(protocol, target, req)
+	resp.Reset()
+
+	var err error
+	if isHTTP2(protocol) {
+		err = s.sendH2(protocol, target, req, resp)
+	} else {
+		address := target.address(protocol)
+		hc := s.clients.getClient(address, s.config)
+		if keepAlive := s.clients.keepAliveState(address); keepAlive == nil || keepAlive.disabled.Load() {
+			req.Header.SetConnectionClose() // Force the server to close the connection
+		} else {
+			keepAlive.requests.Add(1)
+		}
+		err = hc.DoTimeout(req, resp, s.config.RequestTimeout)
 	}
-	return ""
+	if err != nil && !truncatedBody(resp, err) {
+		if s.config.Verbose {
+			fmt.Printf("\n=== Error ===\n")
+			fmt.Printf("Failed to execute request to %s: %v\n", target.url(protocol), err)
+			fmt.Printf("========================\n")
+		}
+		return err
+	}
+	return nil
 }
 
-func extractTitle(body []byte) string {
-	bodyStr := string(body)
-	titleStart := strings.Index(bodyStr, "<title>")
-	if titleStart == -1 {
-		return ""
+// prepareRequest resets req to the request for target using protocol
+func (s *Scanner) prepareRequest(protocol string, target Target, req *fasthttp.Request) {
+	req.Reset()
+	if sni := s.sniName(target, protocol); sni != "" {
+		// The client is pinned to the target address, the URL host only sets the SNI
+		req.SetRequestURI(target.urlWithHost(protocol, sni))
+	} else {
+		req.SetRequestURI(target.url(protocol))
 	}
-	titleStart += len("<title>")
-	titleEnd := strings.Index(bodyStr[titleStart:], "</title>")
-	if titleEnd == -1 {
-		return bodyStr[titleStart:]
+	// Only the Host header carries the candidate, the connection goes to the IP
+	req.Header.SetHost(target.hostHeader(protocol, s.config.HostHeaderPort))
+	req.UseHostHeader = true
+	req.Header.SetUserAgent("Mozilla/5.0 (X11; Linux x86_64)")
+	req.Header.Set("X-Bug-Bounty", "h1-damian89-test")
+	if s.config.Compressed {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
 	}
-	return bodyStr[titleStart : titleStart+titleEnd]
 }
 
+// truncatedBody reports whether err only means that the connection closed
+// before the declared Content-Length was received. Such responses are kept
+// and reported with a length mismatch.
+func truncatedBody(resp *fasthttp.Response, err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) && resp.Header.ContentLength() > len(resp.Body())
+}
+
+// truncateString cuts str to at most maxLen bytes without splitting a UTF-8
+// character
 func truncateString(str string, maxLen int) string {
 	if len(str) <= maxLen {
 		return str
 	}
-	return str[:maxLen] + "..."
+	cut := maxLen
+	for cut > maxLen-utf8.UTFMax+1 && cut > 0 && !utf8.RuneStart(str[cut]) {
+		cut--
+	}
+	return str[:cut] + "..."
 }
 
 // Function to display the progress bar at the bottom of the terminal
@@ -0,0 +1,215 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+)
+
+// checkpointInterval is how often the resume state is persisted
+const checkpointInterval = 10 * time.Second
+
+// scanPosition identifies a target in the chunked IP × host loop of
+// ProcessFilesChunked. Offset counts the targets of the IP/host chunk pair
+// that are already done.
+type scanPosition struct {
+	IPChunk   int64 `json:"ip_chunk"`
+	HostChunk int64 `json:"host_chunk"`
+	Offset    int64 `json:"offset"`
+}
+
+// scanState is the content of the -resume file
+type scanState struct {
+	Inputs           string           `json:"inputs"` // Scan inputs the position refers to
+	Position         scanPosition     `json:"position"`
+	Completed        int64            `json:"completed"`
+	OutputFile       string           `json:"output_file,omitempty"`
+	OutputFormats    []string         `json:"output_formats,omitempty"`
+	HarvestedHosts   []string         `json:"harvested_hosts"` // nil if not harvested yet
+	HarvestEndpoints int64            `json:"harvest_endpoints,omitempty"`
+	Discovered       []discoveredHost `json:"discovered,omitempty"`
+	Finished         bool             `json:"finished"`
+	Stats            *scanStats       `json:"stats,omitempty"` // Statistics of the last run
+	UpdatedAt        time.Time        `json:"updated_at"`
+}
+
+// scanInputs describes everything that determines the order of targets, so a
+// state file is never applied to a different scan
+func scanInputs(cfg config.Config) string {
+	var parts []string
+	for _, file := range []string{cfg.IPsFile, cfg.HostsFile} {
+		size := int64(-1)
+		if info, err := os.Stat(file); err == nil {
+			size = info.Size()
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d", file, size))
+	}
+	parts = append(parts,
+		"paths="+strings.Join(cfg.Paths, ","),
+		"protocols="+strings.Join(cfg.Protocols, ","),
+		fmt.Sprintf("ports=%v", cfg.Ports),
+		fmt.Sprintf("harvest=%t", cfg.HarvestCerts),
+	)
+	return strings.Join(parts, " ")
+}
+
+// loadState reads a state file. A missing file yields a nil state.
+func loadState(path string) (*scanState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state scanState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+// save writes the state atomically, so a crash never leaves a truncated file
+func (st *scanState) save(path string) error {
+	st.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpointTracker assigns sequence numbers to the targets of the chunk loop
+// and tracks the longest prefix of them that has been fully processed. Workers
+// finish targets out of order, so only that prefix is safe to skip on resume.
+type checkpointTracker struct {
+	mu        sync.Mutex
+	nextSeq   int64
+	low       int64 // Every target below low is done
+	done      map[int64]bool
+	positions map[int64]scanPosition
+	resumeAt  scanPosition // Position right after the done prefix
+	base      int64        // Targets completed before this run
+}
+
+func newCheckpointTracker(resumeAt scanPosition, completed int64) *checkpointTracker {
+	return &checkpointTracker{
+		done:      make(map[int64]bool),
+		positions: make(map[int64]scanPosition),
+		resumeAt:  resumeAt,
+		base:      completed,
+	}
+}
+
+// emitted registers a target at pos and returns its sequence number
+func (ct *checkpointTracker) emitted(pos scanPosition) int64 {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	seq := ct.nextSeq
+	ct.nextSeq++
+	ct.positions[seq] = pos
+	return seq
+}
+
+// finished marks a target as fully processed, including its results having
+// been handed to the result channel
+func (ct *checkpointTracker) finished(seq int64) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.done[seq] = true
+	for ct.done[ct.low] {
+		pos := ct.positions[ct.low]
+		pos.Offset++
+		ct.resumeAt = pos
+
+		delete(ct.done, ct.low)
+		delete(ct.positions, ct.low)
+		ct.low++
+	}
+}
+
+// snapshot returns the resume position and the number of completed targets
+func (ct *checkpointTracker) snapshot() (scanPosition, int64) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.resumeAt, ct.base + ct.low
+}
+
+// loadResumeState enables checkpointing and continues the scan recorded in
+// the resume file if it exists
+func (s *Scanner) loadResumeState() error {
+	inputs := scanInputs(s.config)
+	state, err := loadState(s.config.ResumeFile)
+	if err != nil {
+		return err
+	}
+
+	if state == nil {
+		state = &scanState{Inputs: inputs}
+	} else {
+		if state.Inputs != inputs {
+			return fmt.Errorf("%s belongs to a scan with different inputs", s.config.ResumeFile)
+		}
+		s.resumed = true
+
+		// Keep writing to the outputs of the interrupted scan
+		if s.config.OutputFile == "" {
+			s.config.OutputFile = state.OutputFile
+			s.config.OutputFormats = state.OutputFormats
+		}
+
+		s.progressCount = state.Completed
+		s.bar.Set64(state.Completed)
+	}
+
+	state.OutputFile = s.config.OutputFile
+	state.OutputFormats = s.config.OutputFormats
+	s.state = state
+	s.checkpoints = newCheckpointTracker(state.Position, state.Completed)
+	return nil
+}
+
+// checkpoint persists the resume state. Every result of a target below the
+// snapshot is either written already or still buffered in resultChan, so the
+// buffered results are written and all writers flushed before saving.
+func (s *Scanner) checkpoint(writers []ResultWriter, finished bool) {
+	position, completed := s.checkpoints.snapshot()
+
+	for n := len(s.resultChan); n > 0; n-- {
+		result, ok := <-s.resultChan
+		if !ok {
+			break
+		}
+		s.writeResult(writers, result)
+	}
+
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			fmt.Printf("Error flushing output: %v\n", err)
+			return
+		}
+	}
+
+	s.state.Position = position
+	s.state.Completed = completed
+	s.state.Finished = finished
+	if s.discovered != nil {
+		s.state.Discovered = s.discovered.all()
+	}
+	if err := s.state.save(s.config.ResumeFile); err != nil {
+		fmt.Printf("Error saving resume state: %v\n", err)
+	}
+}
@@ -1,9 +1,14 @@
 package scanner
 
 import (
-	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
@@ -12,48 +17,143 @@ import (
 
 type clientCache struct {
 	clients         map[string]*fasthttp.Client
+	probes          map[string]*protocolProbe
 	mu              sync.Mutex
 	followRedirects bool
+	dialer          *fasthttp.TCPDialer
+	proxies         *proxyPool                 // nil without -proxy
+	keepAlive       map[string]*keepAliveState // Per address with -keep-alive
+	h2conns         map[string]*h2Dial         // HTTP/2 connections, see getH2Conn
 }
 
-func newClientCache(followRedirects bool) *clientCache {
+// An address falls back to closing connections once at least
+// keepAliveFailures requests failed on a reused connection and they make up
+// more than 1/keepAliveFailureRatio of its requests. Occasional failures, e.g.
+// from idle timeouts shorter than ours, keep keep-alive enabled.
+const (
+	keepAliveFailures     = 3
+	keepAliveFailureRatio = 10
+)
+
+// keepAliveState tracks how a server handles reused connections
+type keepAliveState struct {
+	address  string
+	verbose  bool
+	requests atomic.Int32 // Requests sent with keep-alive
+	failures atomic.Int32
+	disabled atomic.Bool
+}
+
+func newClientCache(cfg config.Config) *clientCache {
 	return &clientCache{
 		clients:         make(map[string]*fasthttp.Client),
-		followRedirects: followRedirects,
+		probes:          make(map[string]*protocolProbe),
+		followRedirects: cfg.FollowRedirects,
+		dialer: &fasthttp.TCPDialer{
+			Concurrency:      1000,
+			DNSCacheDuration: time.Minute, // Cache DNS results for 1 minute
+			Resolver:         newResolver(cfg),
+		},
+		proxies:   newProxyPool(cfg),
+		keepAlive: make(map[string]*keepAliveState),
+		h2conns:   make(map[string]*h2Dial),
 	}
 }
 
-func (cc *clientCache) getClient(ip string, cfg config.Config) *fasthttp.Client {
+// keepAliveState returns the keep-alive state of an ip:port address, nil
+// without -keep-alive or before the first request
+func (cc *clientCache) keepAliveState(address string) *keepAliveState {
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
+	return cc.keepAlive[address]
+}
 
-	if client, ok := cc.clients[ip]; ok {
+// retryIfErr is the fasthttp.RetryIfErrFunc of keep-alive clients. Requests
+// that fail on a reused connection are retried with Connection: close, a
+// server that keeps breaking reused connections falls back to closing them.
+func (ks *keepAliveState) retryIfErr(req *fasthttp.Request, attempts int, err error) (bool, bool) {
+	if !isReuseError(err) {
+		return false, false
+	}
+	if attempts == 1 {
+		failures := ks.failures.Add(1)
+		requests := ks.requests.Load()
+		if failures >= keepAliveFailures && failures*keepAliveFailureRatio > requests &&
+			ks.disabled.CompareAndSwap(false, true) && ks.verbose {
+			fmt.Printf("[-] Disabling keep-alive for %s after %d of %d requests failed on reused connections\n", ks.address, failures, requests)
+		}
+	}
+	req.Header.SetConnectionClose()
+	return false, true
+}
+
+// retryUnlessTruncated is the fasthttp.RetryIfErrFunc of clients without
+// keep-alive. Like the default it retries failed requests, but not bodies
+// cut short: they would be truncated again and are reported as they are, see
+// truncatedBody.
+func retryUnlessTruncated(_ *fasthttp.Request, _ int, err error) (bool, bool) {
+	return false, !errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isReuseError reports whether err is typical for a server that closed a
+// persistent connection without announcing it
+func isReuseError(err error) bool {
+	return errors.Is(err, fasthttp.ErrConnectionClosed) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// dialTimeout connects to address, through a proxy if configured
+func (cc *clientCache) dialTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	if cc.proxies != nil {
+		return cc.proxies.dial(address, timeout)
+	}
+	return cc.dialer.DialDualStackTimeout(address, timeout)
+}
+
+// getClient returns the client for an ip:port address
+func (cc *clientCache) getClient(address string, cfg config.Config) *fasthttp.Client {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if client, ok := cc.clients[address]; ok {
 		return client
 	}
 
-	// Create a custom dialer with increased timeout for DNS resolution
-	dialer := &fasthttp.TCPDialer{
-		Concurrency:      1000,
-		DNSCacheDuration: time.Minute, // Cache DNS results for 1 minute
-		Resolver: &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{
-					Timeout: 5 * time.Second, // Increase DNS resolution timeout
-				}
-				return d.DialContext(ctx, network, "8.8.8.8:53") // Use Google's public DNS
-			},
-		},
+	dial := cc.dialer.DialDualStack // IPv6 targets need dual stack
+	if cc.proxies != nil {
+		// Every connection is a tunnel through one of the proxies
+		dial = func(addr string) (net.Conn, error) {
+			return cc.proxies.dial(addr, cfg.RequestTimeout)
+		}
+	}
+	if cfg.SNIMode != config.SNINone {
+		// Request URLs carry the SNI name, so every connection is pinned to the target
+		pinned := dial
+		dial = func(string) (net.Conn, error) {
+			return pinned(address)
+		}
 	}
 
 	client := &fasthttp.Client{
-		MaxIdleConnDuration: 1 * time.Second, // Close idle connections after 1 second
-		MaxConnDuration:     5 * time.Second, // Close connections after 5 seconds
+		MaxIdleConnDuration: cfg.MaxIdleConnDuration,
+		MaxConnDuration:     cfg.MaxConnDuration,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		MaxConnWaitTimeout:  cfg.RequestTimeout, // Wait for a free connection instead of failing
 		ReadTimeout:         cfg.ReadTimeout,
 		WriteTimeout:        cfg.WriteTimeout,
-		Dial:                dialer.Dial, // Use the custom dialer
+		Dial:                dial, // Use the custom dialer
+		RetryIfErr:          retryUnlessTruncated,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true, // Targets are IPs, certificates never match
+		},
+	}
+
+	if cfg.KeepAlive {
+		state := &keepAliveState{address: address, verbose: cfg.Verbose}
+		client.RetryIfErr = state.retryIfErr
+		cc.keepAlive[address] = state
 	}
 
-	cc.clients[ip] = client
+	cc.clients[address] = client
 	return client
 }
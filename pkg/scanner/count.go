@@ -4,11 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strings"
 )
 
-func CountTotalTargets(ipsFile, hostsFile string, pathsCount int) (int64, error) {
-	// Count IPs
-	ipsCount, err := countLinesStreaming(ipsFile)
+func CountTotalTargets(ipsFile, hostsFile string, pathsCount, portsCount int) (int64, error) {
+	// Count IP:port endpoints, expanding CIDRs and ranges
+	ipsCount, err := countIPsStreaming(ipsFile, portsCount)
 	if err != nil {
 		return 0, fmt.Errorf("error counting IPs: %v", err)
 	}
@@ -19,7 +20,49 @@ func CountTotalTargets(ipsFile, hostsFile string, pathsCount int) (int64, error)
 		return 0, fmt.Errorf("error counting hosts: %v", err)
 	}
 
-	return int64(ipsCount * hostsCount * pathsCount), nil
+	return ipsCount * int64(hostsCount) * int64(pathsCount), nil
+}
+
+// countIPsStreaming returns the number of endpoints the IPs file expands to.
+// Entries without an explicit port are tested on portsCount ports. Invalid
+// entries are reported and skipped, just like the generator does.
+func countIPsStreaming(filename string, portsCount int) (int64, error) {
+	if portsCount < 1 {
+		portsCount = 1
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, bufferSize), bufferSize)
+
+	var count int64
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry, err := parseIPEntry(line)
+		if err != nil {
+			fmt.Printf("[-] Skipping %v\n", err)
+			continue
+		}
+		if entry.port != 0 {
+			count += int64(entry.size())
+		} else {
+			count += int64(entry.size()) * int64(portsCount)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
 }
 
 func countLinesStreaming(filename string) (int, error) {
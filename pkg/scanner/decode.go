@@ -0,0 +1,101 @@
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+// acceptEncoding is sent with -compressed
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// maxDecodedSize caps decoded bodies, so a decompression bomb cannot exhaust
+// memory. Longer bodies are truncated.
+const maxDecodedSize = 32 * 1024 * 1024
+
+// decodeResponse replaces a compressed body of resp with the decoded one. It
+// returns the body length as received and the decoded Content-Encoding. If
+// decoding fails the body is left as is and the encoding is empty.
+func (s *Scanner) decodeResponse(resp *fasthttp.Response) (int, string) {
+	body := resp.Body()
+	encoding := strings.ToLower(strings.TrimSpace(string(resp.Header.ContentEncoding())))
+	if encoding == "" || encoding == "identity" || len(body) == 0 {
+		return len(body), ""
+	}
+
+	decoded, err := decodeBody(body, encoding)
+	if err != nil {
+		if s.config.Verbose {
+			fmt.Printf("[-] Failed to decode %s body: %v\n", encoding, err)
+		}
+		return len(body), ""
+	}
+	wireLength := len(body)
+	resp.SetBody(decoded)
+	return wireLength, encoding
+}
+
+// decodeBody undoes a Content-Encoding, which lists the codings in the order
+// they were applied
+func decodeBody(body []byte, encoding string) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		body, err = decodeCoding(body, strings.TrimSpace(codings[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func decodeCoding(body []byte, coding string) ([]byte, error) {
+	var reader io.Reader
+	switch coding {
+	case "identity", "":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	case "deflate":
+		// Servers send both zlib-wrapped and raw deflate data
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer zr.Close()
+			reader = zr
+		} else {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			reader = fr
+		}
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecodedSize))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", coding)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedSize))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	// Truncated responses keep what could be decoded
+	return decoded, nil
+}
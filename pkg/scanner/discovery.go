@@ -0,0 +1,199 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// discoveredHost is a hostname extracted from a matched response
+type discoveredHost struct {
+	Hostname string `json:"hostname"`
+	Depth    int    `json:"depth"`
+}
+
+// hostQueue collects hostnames discovered while the scan is running. It also
+// tracks the number of targets that have been emitted but not yet processed,
+// since only those can still discover new hosts.
+type hostQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	hosts   []discoveredHost
+	found   []discoveredHost // Every host ever pushed, persisted for resuming
+	seen    map[string]bool
+	pending int64
+	stopped bool
+}
+
+func newHostQueue() *hostQueue {
+	q := &hostQueue{seen: make(map[string]bool)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// markSeen records a hostname that is already tested without enqueuing it
+func (q *hostQueue) markSeen(host string) {
+	q.mu.Lock()
+	q.seen[strings.ToLower(host)] = true
+	q.mu.Unlock()
+}
+
+// push enqueues host unless it has been seen before
+func (q *hostQueue) push(host string, depth int) bool {
+	host = strings.ToLower(host)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.seen[host] {
+		return false
+	}
+	q.seen[host] = true
+	q.hosts = append(q.hosts, discoveredHost{Hostname: host, Depth: depth})
+	q.found = append(q.found, discoveredHost{Hostname: host, Depth: depth})
+	q.cond.Broadcast()
+	return true
+}
+
+// all returns every host discovered so far
+func (q *hostQueue) all() []discoveredHost {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]discoveredHost(nil), q.found...)
+}
+
+func (q *hostQueue) targetEmitted() {
+	q.mu.Lock()
+	q.pending++
+	q.mu.Unlock()
+}
+
+func (q *hostQueue) targetDone() {
+	q.mu.Lock()
+	q.pending--
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+// wait blocks until hosts have been discovered and returns up to max of them.
+// It returns false once the queue is empty and no target is left that could
+// discover more, or once the queue is stopped.
+func (q *hostQueue) wait(max int) ([]discoveredHost, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.hosts) == 0 && q.pending > 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	if len(q.hosts) == 0 || q.stopped {
+		return nil, false
+	}
+
+	n := len(q.hosts)
+	if n > max {
+		n = max
+	}
+	hosts := make([]discoveredHost, n)
+	copy(hosts, q.hosts)
+	q.hosts = q.hosts[n:]
+	return hosts, true
+}
+
+// stop makes wait return false, e.g. when the scan is interrupted
+func (q *hostQueue) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// inScope reports whether host is one of the apex domains or a subdomain
+func inScope(host string, scope []string) bool {
+	host = strings.ToLower(host)
+	for _, apex := range scope {
+		if host == apex || strings.HasSuffix(host, "."+apex) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeRegexp matches hostnames below any of the apex domains. The hostname
+// is the first group, it must not be part of a longer name on either side,
+// e.g. notexample.com or example.com.evil.net.
+func scopeRegexp(scope []string) *regexp.Regexp {
+	apexes := make([]string, len(scope))
+	for i, apex := range scope {
+		apexes[i] = regexp.QuoteMeta(apex)
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^a-z0-9.-])((?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)*(?:` + strings.Join(apexes, "|") + `))(?:[^a-z0-9.-]|$)`)
+}
+
+// scopeMatches returns the hostnames scopeRegexp finds in source. Matching
+// resumes right after each hostname, so the boundary character ending one
+// can start the next.
+func (s *Scanner) scopeMatches(source []byte) []string {
+	var hosts []string
+	for start := 0; start < len(source); {
+		loc := s.scopePattern.FindSubmatchIndex(source[start:])
+		if loc == nil {
+			break
+		}
+		host := strings.ToLower(string(source[start+loc[2] : start+loc[3]]))
+		if inScope(host, s.config.RecursiveScope) {
+			hosts = append(hosts, host)
+		}
+		start += loc[3]
+	}
+	return hosts
+}
+
+// discoverHosts enqueues in-scope hostnames found in the headers and body of
+// a matched response, e.g. in Location, CORS or CSP headers, links and scripts
+func (s *Scanner) discoverHosts(target Target, resp *fasthttp.Response) {
+	if target.Depth >= s.config.RecursiveDepth {
+		return
+	}
+
+	for _, source := range [][]byte{resp.Header.Header(), resp.Body()} {
+		for _, host := range s.scopeMatches(source) {
+			if host == strings.ToLower(target.Hostname) {
+				continue
+			}
+			if s.discovered.push(host, target.Depth+1) && s.config.Verbose {
+				fmt.Printf("[*] Discovered host %s (depth %d) via %s\n", host, target.Depth+1, target.Hostname)
+			}
+		}
+	}
+}
+
+// seedDiscovered marks the in-scope hosts that are tested anyway as seen, so
+// discovering them again does not enqueue duplicates
+func (s *Scanner) seedDiscovered(extraHosts []string) error {
+	file, err := os.Open(s.config.HostsFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, bufferSize), bufferSize)
+	for scanner.Scan() {
+		if host := strings.TrimSpace(scanner.Text()); inScope(host, s.config.RecursiveScope) {
+			s.discovered.markSeen(host)
+		}
+	}
+
+	for _, host := range extraHosts {
+		if inScope(host, s.config.RecursiveScope) {
+			s.discovered.markSeen(host)
+		}
+	}
+	return scanner.Err()
+}
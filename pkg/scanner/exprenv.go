@@ -0,0 +1,77 @@
+package scanner
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// responseEnv exposes a response to -expr programs, see config.ExprVars
+type responseEnv struct {
+	target     Target
+	protocol   string
+	resp       *Response
+	baseline   *responseFingerprint // nil without calibration
+	similarity int
+}
+
+func (e *responseEnv) Lookup(name string) interface{} {
+	switch name {
+	case "status":
+		return float64(e.resp.StatusCode)
+	case "length":
+		return float64(len(e.resp.Body))
+	case "words":
+		return float64(e.resp.Words())
+	case "lines":
+		return float64(e.resp.Lines())
+	case "time":
+		return float64(e.resp.Elapsed.Milliseconds())
+	case "body":
+		return string(e.resp.Body)
+	case "title":
+		return e.resp.Title
+	case "content_type":
+		return string(e.resp.Header.ContentType())
+	case "headers":
+		return headerMap{e.resp.Header}
+	case "host":
+		return e.target.Hostname
+	case "ip":
+		return e.target.IP
+	case "port":
+		return float64(e.target.effectivePort(e.protocol))
+	case "path":
+		return e.target.Path
+	case "protocol":
+		return e.protocol
+	case "location":
+		return e.resp.Location
+	case "redirect":
+		return e.resp.RedirectType
+	case "similarity":
+		return float64(e.similarity)
+	}
+
+	if e.baseline == nil {
+		return float64(-1)
+	}
+	switch name {
+	case "baseline_status":
+		return float64(e.baseline.StatusCode)
+	case "baseline_length":
+		return float64(e.baseline.Length)
+	case "baseline_words":
+		return float64(e.baseline.Words)
+	case "baseline_lines":
+		return float64(e.baseline.Lines)
+	}
+	panic("unknown expression variable: " + name)
+}
+
+// headerMap looks up response headers case-insensitively
+type headerMap struct {
+	header *fasthttp.ResponseHeader
+}
+
+func (h headerMap) Get(key string) string {
+	return string(h.header.Peek(key))
+}
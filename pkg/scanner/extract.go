@@ -0,0 +1,174 @@
+package scanner
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+// maxExtractedValues caps the values kept per extractor and result
+const maxExtractedValues = 50
+
+// Extractor captures values from a response that is reported
+type Extractor interface {
+	Name() string
+	Extract(resp *Response) []string
+}
+
+// newExtractor builds the extractor of a spec validated by config.ParseFlags
+func newExtractor(spec config.ExtractorSpec) Extractor {
+	switch spec.Kind {
+	case config.ExtractRegex:
+		return &regexExtractor{name: spec.Name, pattern: spec.Pattern}
+	case config.ExtractHeader:
+		return &headerExtractor{name: spec.Name, header: spec.Header}
+	case config.ExtractJSON:
+		return &jsonExtractor{name: spec.Name, path: spec.Path}
+	}
+	panic("unknown extractor kind: " + spec.Kind)
+}
+
+// extractValues runs all extractors and returns the distinct values per name,
+// nil if nothing was captured
+func extractValues(extractors []Extractor, resp *Response) map[string][]string {
+	var extracted map[string][]string
+	for _, e := range extractors {
+		seen := make(map[string]bool)
+		for _, value := range e.Extract(resp) {
+			if value == "" || seen[value] || len(seen) >= maxExtractedValues {
+				continue
+			}
+			seen[value] = true
+			if extracted == nil {
+				extracted = make(map[string][]string)
+			}
+			extracted[e.Name()] = append(extracted[e.Name()], value)
+		}
+	}
+	return extracted
+}
+
+// regexExtractor captures the matches of a pattern, or its first group if it
+// has one
+type regexExtractor struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func (e *regexExtractor) Name() string { return e.name }
+
+func (e *regexExtractor) Extract(resp *Response) []string {
+	group := 0
+	if e.pattern.NumSubexp() > 0 {
+		group = 1
+	}
+
+	var values []string
+	for _, match := range e.pattern.FindAllSubmatch(resp.Body, -1) {
+		values = append(values, string(match[group]))
+	}
+	return values
+}
+
+type headerExtractor struct {
+	name   string
+	header string
+}
+
+func (e *headerExtractor) Name() string { return e.name }
+
+func (e *headerExtractor) Extract(resp *Response) []string {
+	var values []string
+	if strings.EqualFold(e.header, fasthttp.HeaderSetCookie) {
+		// fasthttp joins cookies into a single value
+		resp.Header.VisitAllCookie(func(_, value []byte) {
+			values = append(values, string(value))
+		})
+		return values
+	}
+
+	for _, value := range resp.Header.PeekAll(e.header) {
+		values = append(values, string(value))
+	}
+	return values
+}
+
+// jsonExtractor captures the values at a path in a JSON body. Objects and
+// arrays are captured as JSON.
+type jsonExtractor struct {
+	name string
+	path []string
+}
+
+func (e *jsonExtractor) Name() string { return e.name }
+
+func (e *jsonExtractor) Extract(resp *Response) []string {
+	doc, ok := resp.JSON()
+	if !ok {
+		return nil
+	}
+
+	var values []string
+	for _, v := range lookupJSON(doc, e.path) {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			values = append(values, v)
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		default:
+			if encoded, err := json.Marshal(v); err == nil {
+				values = append(values, string(encoded))
+			}
+		}
+	}
+	return values
+}
+
+// lookupJSON returns the values at path, which may contain * to descend into
+// every element of an array or object
+func lookupJSON(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+
+	key, rest := path[0], path[1:]
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if key == "*" {
+			keys := make([]string, 0, len(node))
+			for k := range node {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			var values []interface{}
+			for _, k := range keys {
+				values = append(values, lookupJSON(node[k], rest)...)
+			}
+			return values
+		}
+		if child, ok := node[key]; ok {
+			return lookupJSON(child, rest)
+		}
+	case []interface{}:
+		if key == "*" {
+			var values []interface{}
+			for _, child := range node {
+				values = append(values, lookupJSON(child, rest)...)
+			}
+			return values
+		}
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node) {
+			return lookupJSON(node[i], rest)
+		}
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package scanner
 
 import (
 	"bufio"
+	"context"
 	"os"
 	"strings"
+	"sync"
 )
 
 const (
@@ -18,20 +20,66 @@ type BatchProcessor struct {
 	ipFile     *os.File
 	hostFile   *os.File
 	paths      []string
+	ports      []int    // Ports used for entries without an explicit one, empty for protocol defaults
+	extraHosts []string // Hosts discovered before the scan, tested after the hosts file
 	targetChan chan Target
 	batchSize  int
+	onIPChunk  func(context.Context, []endpoint) // Called before the targets of an IP chunk are emitted
+	onIPDone   func([]endpoint)                  // Called once all targets of an IP chunk are finished
+	discovered *hostQueue                        // Hosts discovered while scanning, nil unless recursive
+	onGrow     func(n int64)                     // Called when targets for discovered hosts are added
+	tracker    *checkpointTracker                // Tracks the chunk loop position, nil unless resumable
+	resumeAt   scanPosition                      // Targets before this position are skipped
 }
 
-func (bp *BatchProcessor) ProcessFilesChunked() error {
+// emit hands a target to the workers, keeping track of it for discovery. It
+// fails once ctx is cancelled.
+func (bp *BatchProcessor) emit(ctx context.Context, target Target) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if bp.discovered != nil {
+		bp.discovered.targetEmitted()
+	}
+	if target.chunk != nil {
+		target.chunk.Add(1)
+	}
+	select {
+	case bp.targetChan <- target:
+		return nil
+	case <-ctx.Done():
+		if bp.discovered != nil {
+			bp.discovered.targetDone()
+		}
+		if target.chunk != nil {
+			target.chunk.Done()
+		}
+		return ctx.Err()
+	}
+}
+
+// emitAt emits a target of the chunk loop at pos
+func (bp *BatchProcessor) emitAt(ctx context.Context, target Target, pos scanPosition) error {
+	target.seq = -1
+	if bp.tracker != nil {
+		target.seq = bp.tracker.emitted(pos)
+	}
+	return bp.emit(ctx, target)
+}
+
+// ProcessFilesChunked emits all targets until done or ctx is cancelled
+func (bp *BatchProcessor) ProcessFilesChunked(ctx context.Context) error {
 	defer close(bp.targetChan)
 
-	// Wrap both files in bufio.Scanners
+	// Wrap both files in bufio.Scanners, IP entries are expanded lazily
 	ipScanner := bufio.NewScanner(bp.ipFile)
 	ipScanner.Buffer(make([]byte, bufferSize), bufferSize)
+	ips := newIPIterator(ipScanner)
 
-	for {
+	for ipIndex := int64(0); ; ipIndex++ {
 		// 1) Read a chunk of IPs (up to defaultIPChunkSize)
-		ipChunk, err := readChunk(ipScanner, defaultIPChunkSize)
+		ipChunk, err := ips.readChunk(defaultIPChunkSize)
 		if err != nil {
 			return err
 		}
@@ -39,17 +87,32 @@ func (bp *BatchProcessor) ProcessFilesChunked() error {
 			// No more IPs left
 			break
 		}
+		if ipIndex < bp.resumeAt.IPChunk {
+			// Already done in a previous run
+			continue
+		}
+		ipChunk = expandPorts(ipChunk, bp.ports)
+
+		if bp.onIPChunk != nil {
+			bp.onIPChunk(ctx, ipChunk)
+		}
+
+		// Held until all targets of the chunk are emitted
+		var pending *sync.WaitGroup
+		if bp.onIPDone != nil {
+			pending = &sync.WaitGroup{}
+			pending.Add(1)
+		}
 
 		// 2) For each chunk of IPs, we need to re‐scan the hosts file from the beginning
-		if _, err := bp.hostFile.Seek(0, 0); err != nil {
+		hosts, err := bp.newHostReader()
+		if err != nil {
 			return err
 		}
-		hostScanner := bufio.NewScanner(bp.hostFile)
-		hostScanner.Buffer(make([]byte, bufferSize), bufferSize)
 
-		for {
+		for hostIndex := int64(0); ; hostIndex++ {
 			// 3) Read a chunk of hosts (up to defaultHostChunkSize)
-			hostChunk, err := readChunk(hostScanner, defaultHostChunkSize)
+			hostChunk, err := hosts.readChunk(defaultHostChunkSize)
 			if err != nil {
 				return err
 			}
@@ -57,24 +120,160 @@ func (bp *BatchProcessor) ProcessFilesChunked() error {
 				// No more hosts left
 				break
 			}
+			if ipIndex == bp.resumeAt.IPChunk && hostIndex < bp.resumeAt.HostChunk {
+				// Already done in a previous run
+				continue
+			}
+
+			var skip int64
+			if bp.resumeAt.IPChunk == ipIndex && bp.resumeAt.HostChunk == hostIndex {
+				skip = bp.resumeAt.Offset
+			}
 
 			// 4) Emit cross product of IP chunk and host chunk (plus all paths)
-			for _, ip := range ipChunk {
+			var offset int64
+			for _, ep := range ipChunk {
 				for _, host := range hostChunk {
 					for _, path := range bp.paths {
-						bp.targetChan <- Target{
-							IP:       ip,
+						if offset < skip {
+							offset++
+							continue
+						}
+						err := bp.emitAt(ctx, Target{
+							IP:       ep.IP,
+							Port:     ep.Port,
 							Hostname: host,
 							Path:     path,
+							chunk:    pending,
+						}, scanPosition{IPChunk: ipIndex, HostChunk: hostIndex, Offset: offset})
+						if err != nil {
+							return err
 						}
+						offset++
 					}
 				}
 			}
 		}
+
+		if pending != nil {
+			pending.Done()
+			go func(ipChunk []endpoint) {
+				pending.Wait()
+				bp.onIPDone(ipChunk)
+			}(ipChunk)
+		}
+	}
+
+	if bp.discovered != nil {
+		return bp.processDiscoveredHosts(ctx)
 	}
 	return nil
 }
 
+// processDiscoveredHosts emits every discovered host against all IPs until
+// no more hosts are discovered
+func (bp *BatchProcessor) processDiscoveredHosts(ctx context.Context) error {
+	for {
+		hosts, ok := bp.discovered.wait(defaultHostChunkSize)
+		if !ok {
+			return nil
+		}
+
+		if _, err := bp.ipFile.Seek(0, 0); err != nil {
+			return err
+		}
+		ipScanner := bufio.NewScanner(bp.ipFile)
+		ipScanner.Buffer(make([]byte, bufferSize), bufferSize)
+		ips := newIPIterator(ipScanner)
+
+		for {
+			ipChunk, err := ips.readChunk(defaultIPChunkSize)
+			if err != nil {
+				return err
+			}
+			if len(ipChunk) == 0 {
+				break
+			}
+			ipChunk = expandPorts(ipChunk, bp.ports)
+
+			if bp.onGrow != nil {
+				bp.onGrow(int64(len(ipChunk)) * int64(len(hosts)) * int64(len(bp.paths)))
+			}
+
+			for _, ep := range ipChunk {
+				for _, host := range hosts {
+					for _, path := range bp.paths {
+						err := bp.emit(ctx, Target{
+							IP:       ep.IP,
+							Port:     ep.Port,
+							Hostname: host.Hostname,
+							Path:     path,
+							Depth:    host.Depth,
+							seq:      -1,
+						})
+						if err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// expandPorts expands endpoints without an explicit port to all given ports
+func expandPorts(endpoints []endpoint, ports []int) []endpoint {
+	if len(ports) == 0 {
+		return endpoints
+	}
+
+	expanded := make([]endpoint, 0, len(endpoints)*len(ports))
+	for _, ep := range endpoints {
+		if ep.Port != 0 {
+			expanded = append(expanded, ep)
+			continue
+		}
+		for _, port := range ports {
+			expanded = append(expanded, endpoint{IP: ep.IP, Port: port})
+		}
+	}
+	return expanded
+}
+
+// hostReader yields the hostnames of the hosts file followed by the extra
+// hosts discovered before the scan
+type hostReader struct {
+	scanner *bufio.Scanner
+	extra   []string
+}
+
+func (bp *BatchProcessor) newHostReader() (*hostReader, error) {
+	if _, err := bp.hostFile.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	hostScanner := bufio.NewScanner(bp.hostFile)
+	hostScanner.Buffer(make([]byte, bufferSize), bufferSize)
+
+	return &hostReader{
+		scanner: hostScanner,
+		extra:   bp.extraHosts,
+	}, nil
+}
+
+func (hr *hostReader) readChunk(chunkSize int) ([]string, error) {
+	chunk, err := readChunk(hr.scanner, chunkSize)
+	if err != nil || len(chunk) > 0 {
+		return chunk, err
+	}
+
+	n := len(hr.extra)
+	if n > chunkSize {
+		n = chunkSize
+	}
+	chunk, hr.extra = hr.extra[:n], hr.extra[n:]
+	return chunk, nil
+}
+
 func readChunk(scanner *bufio.Scanner, chunkSize int) ([]string, error) {
 	var lines []string
 	for len(lines) < chunkSize && scanner.Scan() {
@@ -87,7 +286,7 @@ func readChunk(scanner *bufio.Scanner, chunkSize int) ([]string, error) {
 	return lines, scanner.Err()
 }
 
-func NewBatchProcessor(ipPath, hostPath string, paths []string, targetChan chan Target) (*BatchProcessor, error) {
+func NewBatchProcessor(ipPath, hostPath string, paths []string, ports []int, targetChan chan Target) (*BatchProcessor, error) {
 	ipFile, err := os.Open(ipPath)
 	if err != nil {
 		return nil, err
@@ -103,6 +302,7 @@ func NewBatchProcessor(ipPath, hostPath string, paths []string, targetChan chan
 		ipFile:     ipFile,
 		hostFile:   hostFile,
 		paths:      paths,
+		ports:      ports,
 		targetChan: targetChan,
 		batchSize:  batchSize,
 	}, nil
@@ -113,27 +313,30 @@ func (bp *BatchProcessor) Close() {
 	bp.hostFile.Close()
 }
 
-func (bp *BatchProcessor) ProcessFiles() error {
+func (bp *BatchProcessor) ProcessFiles(ctx context.Context) error {
 	defer close(bp.targetChan)
 
 	ipScanner := bufio.NewScanner(bp.ipFile)
 	ipScanner.Buffer(make([]byte, bufferSize), bufferSize)
+	ips := newIPIterator(ipScanner)
 
-	for ipScanner.Scan() {
-		ip := ipScanner.Text()
-		if ip == "" {
-			continue
+	for {
+		ep, ok := ips.next()
+		if !ok {
+			break
 		}
 
-		if err := bp.processIPWithHosts(ip); err != nil {
-			return err
+		for _, ep := range expandPorts([]endpoint{ep}, bp.ports) {
+			if err := bp.processIPWithHosts(ctx, ep); err != nil {
+				return err
+			}
 		}
 	}
 
 	return ipScanner.Err()
 }
 
-func (bp *BatchProcessor) processIPWithHosts(ip string) error {
+func (bp *BatchProcessor) processIPWithHosts(ctx context.Context, ep endpoint) error {
 	_, err := bp.hostFile.Seek(0, 0)
 	if err != nil {
 		return err
@@ -149,10 +352,15 @@ func (bp *BatchProcessor) processIPWithHosts(ip string) error {
 		}
 
 		for _, path := range bp.paths {
-			bp.targetChan <- Target{
-				IP:       ip,
+			err := bp.emit(ctx, Target{
+				IP:       ep.IP,
+				Port:     ep.Port,
 				Hostname: host,
 				Path:     path,
+				seq:      -1,
+			})
+			if err != nil {
+				return err
 			}
 		}
 	}
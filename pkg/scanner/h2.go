@@ -0,0 +1,626 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// The HTTP/2 client below is used for the h2 (TLS with ALPN) and h2c (prior
+// knowledge) protocols. Unlike net/http it sends :authority and Host exactly
+// as configured, and the candidates for an IP share one connection as
+// concurrent streams.
+
+const (
+	h2WindowSize     = 4 << 20 // Flow control window granted to servers
+	h2MaxStreams     = 100     // Concurrent streams per connection unless the server allows fewer
+	h2MaxHeaderBytes = 1 << 20
+	h2MaxFrameSize   = 16384 // Default SETTINGS_MAX_FRAME_SIZE
+	h2MaxStreamID    = 1<<31 - 1
+)
+
+var errH2Idle = errors.New("http2: idle connection closed")
+
+// h2UnprocessedError is returned for requests the server did not process:
+// streams above the last stream ID of a GOAWAY, streams refused with
+// REFUSED_STREAM, and requests for a connection that could no longer open
+// streams. They are safe to send again on a new connection.
+type h2UnprocessedError struct{ err error }
+
+func (e h2UnprocessedError) Error() string { return e.err.Error() }
+func (e h2UnprocessedError) Unwrap() error { return e.err }
+
+// h2Conn is a client connection multiplexing requests as streams
+type h2Conn struct {
+	conn         net.Conn
+	bw           *bufio.Writer
+	framer       *http2.Framer
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	wmu  sync.Mutex // Guards writes, including the header encoder
+	henc *hpack.Encoder
+	hbuf bytes.Buffer
+
+	mu         sync.Mutex
+	streams    map[uint32]*h2Stream
+	nextID     uint32
+	maxStreams uint32
+	freed      chan struct{} // Closed and replaced when a stream slot may be free
+	idleTimer  *time.Timer
+	err        error // Set once no new streams may be opened
+
+	closeOnce sync.Once
+	onClose   func() // Called once the connection is closed
+}
+
+// h2Stream is a request in flight
+type h2Stream struct {
+	recv h2Response // Owned by the read loop
+
+	// Set before done is closed: resp on success, err otherwise
+	resp *h2Response
+	err  error
+	done chan struct{}
+}
+
+type h2Response struct {
+	status int
+	header []hpack.HeaderField
+	body   bytes.Buffer
+}
+
+// newH2Conn starts HTTP/2 on conn. The server has to answer the connection
+// preface with its settings, anything else is not HTTP/2. onClose is called
+// once the connection is closed.
+func newH2Conn(conn net.Conn, cfg config.Config, onClose func()) (*h2Conn, error) {
+	cc := &h2Conn{
+		conn:         conn,
+		onClose:      onClose,
+		bw:           bufio.NewWriter(conn),
+		writeTimeout: cfg.WriteTimeout,
+		idleTimeout:  cfg.MaxIdleConnDuration,
+		streams:      make(map[uint32]*h2Stream),
+		nextID:       1,
+		maxStreams:   h2MaxStreams,
+		freed:        make(chan struct{}),
+	}
+	cc.framer = http2.NewFramer(cc.bw, bufio.NewReader(conn))
+	cc.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	cc.framer.MaxHeaderListSize = h2MaxHeaderBytes
+	cc.henc = hpack.NewEncoder(&cc.hbuf)
+
+	conn.SetDeadline(time.Now().Add(cfg.RequestTimeout))
+	io.WriteString(cc.bw, http2.ClientPreface)
+	cc.framer.WriteSettings(
+		http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2WindowSize},
+		http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: h2MaxHeaderBytes},
+	)
+	cc.framer.WriteWindowUpdate(0, h2WindowSize)
+	if err := cc.bw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	frame, err := cc.framer.ReadFrame()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no HTTP/2 response: %w", err)
+	}
+	settings, ok := frame.(*http2.SettingsFrame)
+	if !ok || settings.IsAck() {
+		conn.Close()
+		return nil, fmt.Errorf("no HTTP/2 settings, got %s frame", frame.Header().Type)
+	}
+	conn.SetDeadline(time.Time{})
+	if err := cc.applySettings(settings); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if cc.idleTimeout > 0 {
+		cc.idleTimer = time.AfterFunc(cc.idleTimeout, cc.closeIdle)
+	}
+	go cc.readLoop()
+	return cc, nil
+}
+
+// usable reports whether new streams may be opened on the connection
+func (cc *h2Conn) usable() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.err == nil
+}
+
+// roundTrip sends a request without body and waits for its response
+func (cc *h2Conn) roundTrip(fields []hpack.HeaderField, timeout time.Duration) (*h2Stream, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	// Stream IDs must be used in increasing order, so the ID is taken and the
+	// headers are written under the write lock
+	st := &h2Stream{done: make(chan struct{})}
+	var id uint32
+	for {
+		cc.wmu.Lock()
+		cc.mu.Lock()
+		if cc.err != nil {
+			err := cc.err
+			cc.mu.Unlock()
+			cc.wmu.Unlock()
+			return nil, h2UnprocessedError{err}
+		}
+		if uint32(len(cc.streams)) < cc.maxStreams {
+			id = cc.nextID
+			cc.nextID += 2
+			if cc.nextID > h2MaxStreamID {
+				cc.err = errors.New("http2: stream IDs exhausted")
+			}
+			cc.streams[id] = st
+			if cc.idleTimer != nil {
+				cc.idleTimer.Stop()
+				cc.idleTimer = nil
+			}
+			cc.mu.Unlock()
+			break
+		}
+		freed := cc.freed
+		cc.mu.Unlock()
+		cc.wmu.Unlock()
+
+		select {
+		case <-freed:
+		case <-deadline.C:
+			return nil, fasthttp.ErrTimeout
+		}
+	}
+
+	err := cc.writeHeaders(id, fields)
+	cc.wmu.Unlock()
+	if err != nil {
+		cc.close(err)
+		return nil, err
+	}
+
+	select {
+	case <-st.done:
+		if st.err != nil {
+			return nil, st.err
+		}
+		if st.resp.status == 0 {
+			return nil, errors.New("http2: stream ended without a response")
+		}
+		return st, nil
+	case <-deadline.C:
+		cc.endStream(id, fasthttp.ErrTimeout)
+		cc.write(func() error { return cc.framer.WriteRSTStream(id, http2.ErrCodeCancel) })
+		return nil, fasthttp.ErrTimeout
+	}
+}
+
+// writeHeaders writes the request headers of stream id, which also end the
+// stream. The caller holds the write lock.
+func (cc *h2Conn) writeHeaders(id uint32, fields []hpack.HeaderField) error {
+	cc.hbuf.Reset()
+	for _, f := range fields {
+		cc.henc.WriteField(f)
+	}
+	block := cc.hbuf.Bytes()
+
+	cc.conn.SetWriteDeadline(time.Now().Add(cc.writeTimeout))
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if len(chunk) > h2MaxFrameSize {
+			chunk = chunk[:h2MaxFrameSize]
+		}
+		block = block[len(chunk):]
+
+		var err error
+		if first {
+			err = cc.framer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      id,
+				BlockFragment: chunk,
+				EndStream:     true,
+				EndHeaders:    len(block) == 0,
+			})
+		} else {
+			err = cc.framer.WriteContinuation(id, len(block) == 0, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return cc.bw.Flush()
+}
+
+// write runs a framer write under the write lock and flushes it
+func (cc *h2Conn) write(fn func() error) {
+	cc.wmu.Lock()
+	cc.conn.SetWriteDeadline(time.Now().Add(cc.writeTimeout))
+	err := fn()
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	cc.wmu.Unlock()
+	if err != nil {
+		cc.close(err)
+	}
+}
+
+func (cc *h2Conn) readLoop() {
+	for {
+		frame, err := cc.framer.ReadFrame()
+		if err != nil {
+			var streamErr http2.StreamError
+			if errors.As(err, &streamErr) {
+				cc.endStream(streamErr.StreamID, streamErr)
+				cc.write(func() error { return cc.framer.WriteRSTStream(streamErr.StreamID, streamErr.Code) })
+				continue
+			}
+			cc.close(err)
+			return
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err := cc.applySettings(f); err != nil {
+					cc.close(err)
+					return
+				}
+			}
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				cc.write(func() error { return cc.framer.WritePing(true, f.Data) })
+			}
+		case *http2.MetaHeadersFrame:
+			cc.readHeaders(f)
+		case *http2.DataFrame:
+			cc.readData(f)
+		case *http2.RSTStreamFrame:
+			var err error = http2.StreamError{StreamID: f.StreamID, Code: f.ErrCode}
+			if f.ErrCode == http2.ErrCodeRefusedStream {
+				err = h2UnprocessedError{err}
+			}
+			cc.endStream(f.StreamID, err)
+		case *http2.GoAwayFrame:
+			cc.goAway(f)
+		}
+	}
+}
+
+// applySettings takes over the stream limit and header table size of the
+// server and acknowledges its settings
+func (cc *h2Conn) applySettings(f *http2.SettingsFrame) error {
+	if limit, ok := f.Value(http2.SettingMaxConcurrentStreams); ok {
+		cc.mu.Lock()
+		cc.maxStreams = min(limit, h2MaxStreams)
+		cc.signalFreed()
+		cc.mu.Unlock()
+	}
+
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	if size, ok := f.Value(http2.SettingHeaderTableSize); ok {
+		cc.henc.SetMaxDynamicTableSizeLimit(size)
+	}
+	cc.conn.SetWriteDeadline(time.Now().Add(cc.writeTimeout))
+	if err := cc.framer.WriteSettingsAck(); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
+
+func (cc *h2Conn) stream(id uint32) *h2Stream {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.streams[id]
+}
+
+func (cc *h2Conn) readHeaders(f *http2.MetaHeadersFrame) {
+	st := cc.stream(f.StreamID)
+	if st == nil {
+		return
+	}
+
+	// Informational responses precede the final one, later headers are
+	// trailers
+	if st.recv.status == 0 {
+		status, err := strconv.Atoi(f.PseudoValue("status"))
+		if err != nil {
+			cc.endStream(f.StreamID, fmt.Errorf("http2: invalid :status %q", f.PseudoValue("status")))
+			return
+		}
+		if status < 200 {
+			return
+		}
+		st.recv.status = status
+		st.recv.header = f.RegularFields()
+	}
+	if f.StreamEnded() {
+		cc.endStream(f.StreamID, nil)
+	}
+}
+
+func (cc *h2Conn) readData(f *http2.DataFrame) {
+	st := cc.stream(f.StreamID)
+	if st != nil {
+		st.recv.body.Write(f.Data())
+	}
+
+	// Give back the flow control credit right away, padding included
+	if f.Length > 0 {
+		cc.write(func() error {
+			if err := cc.framer.WriteWindowUpdate(0, f.Length); err != nil || st == nil || f.StreamEnded() {
+				return err
+			}
+			return cc.framer.WriteWindowUpdate(f.StreamID, f.Length)
+		})
+	}
+	if st != nil && f.StreamEnded() {
+		cc.endStream(f.StreamID, nil)
+	}
+}
+
+// goAway fails the streams the server will not process, the others may
+// still complete
+func (cc *h2Conn) goAway(f *http2.GoAwayFrame) {
+	err := fmt.Errorf("http2: server sent GOAWAY (%v)", f.ErrCode)
+	cc.mu.Lock()
+	if cc.err == nil {
+		cc.err = err
+	}
+	var failed []*h2Stream
+	for id, st := range cc.streams {
+		if id > f.LastStreamID {
+			failed = append(failed, st)
+			delete(cc.streams, id)
+		}
+	}
+	cc.mu.Unlock()
+
+	for _, st := range failed {
+		st.err = h2UnprocessedError{err}
+		close(st.done)
+	}
+	cc.closeIfDrained()
+}
+
+// endStream completes stream id, err is nil on success. Only the read loop
+// completes streams successfully, so it may publish the response.
+func (cc *h2Conn) endStream(id uint32, err error) {
+	cc.mu.Lock()
+	st := cc.streams[id]
+	if st == nil {
+		cc.mu.Unlock()
+		return
+	}
+	delete(cc.streams, id)
+	cc.signalFreed()
+	if len(cc.streams) == 0 && cc.err == nil && cc.idleTimeout > 0 {
+		cc.idleTimer = time.AfterFunc(cc.idleTimeout, cc.closeIdle)
+	}
+	cc.mu.Unlock()
+
+	if err == nil {
+		st.resp = &st.recv
+	}
+	st.err = err
+	close(st.done)
+	cc.closeIfDrained()
+}
+
+// closeIfDrained closes a connection that can no longer open streams once
+// its last stream ended
+func (cc *h2Conn) closeIfDrained() {
+	cc.mu.Lock()
+	drained := cc.err != nil && len(cc.streams) == 0
+	cc.mu.Unlock()
+	if drained {
+		cc.shutdown()
+	}
+}
+
+// shutdown closes the network connection, the read loop then ends
+func (cc *h2Conn) shutdown() {
+	cc.closeOnce.Do(func() {
+		cc.conn.Close()
+		if cc.onClose != nil {
+			cc.onClose()
+		}
+	})
+}
+
+// signalFreed wakes up requests waiting for a stream slot. The caller holds
+// cc.mu.
+func (cc *h2Conn) signalFreed() {
+	close(cc.freed)
+	cc.freed = make(chan struct{})
+}
+
+func (cc *h2Conn) closeIdle() {
+	cc.mu.Lock()
+	idle := len(cc.streams) == 0 && cc.err == nil
+	cc.mu.Unlock()
+	if idle {
+		cc.close(errH2Idle)
+	}
+}
+
+// close fails all streams and closes the connection
+func (cc *h2Conn) close(err error) {
+	cc.mu.Lock()
+	if cc.err == nil {
+		cc.err = err
+	}
+	streams := cc.streams
+	cc.streams = make(map[uint32]*h2Stream)
+	cc.signalFreed()
+	cc.mu.Unlock()
+
+	cc.shutdown()
+	for _, st := range streams {
+		st.err = err
+		close(st.done)
+	}
+}
+
+// copyTo converts the response into resp
+func (r *h2Response) copyTo(resp *fasthttp.Response) {
+	resp.SetStatusCode(r.status)
+	for _, f := range r.header {
+		resp.Header.Add(f.Name, f.Value)
+	}
+	resp.SetBody(r.body.Bytes())
+}
+
+// h2Dial is a pooled HTTP/2 connection, done is closed once it is dialed
+type h2Dial struct {
+	done chan struct{}
+	conn *h2Conn
+	err  error
+}
+
+// getH2Conn returns the HTTP/2 connection for protocol, address and TLS
+// server name, dialing a new one if there is none or the last one can no
+// longer open streams. Connections leave the cache when they close, so
+// per-candidate SNI names do not accumulate.
+func (cc *clientCache) getH2Conn(protocol, address, sni string, cfg config.Config) (*h2Conn, error) {
+	key := protocol + "://" + address + "/" + sni
+
+	cc.mu.Lock()
+	d := cc.h2conns[key]
+	var replaced *h2Conn
+	if d != nil {
+		select {
+		case <-d.done:
+			if d.err != nil || !d.conn.usable() {
+				replaced = d.conn
+				d = nil
+			}
+		default:
+		}
+	}
+	if d != nil {
+		cc.mu.Unlock()
+		<-d.done
+		return d.conn, d.err
+	}
+	d = &h2Dial{done: make(chan struct{})}
+	cc.h2conns[key] = d
+	cc.mu.Unlock()
+
+	// The replaced connection is closed once its remaining streams end
+	if replaced != nil {
+		replaced.closeIfDrained()
+	}
+
+	remove := func() {
+		cc.mu.Lock()
+		if cc.h2conns[key] == d {
+			delete(cc.h2conns, key)
+		}
+		cc.mu.Unlock()
+	}
+	d.conn, d.err = cc.dialH2(protocol, address, sni, cfg, remove)
+	close(d.done)
+	if d.err != nil {
+		remove()
+	}
+	return d.conn, d.err
+}
+
+func (cc *clientCache) dialH2(protocol, address, sni string, cfg config.Config, onClose func()) (*h2Conn, error) {
+	conn, err := cc.dialTimeout(address, cfg.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == "h2" {
+		conn.SetDeadline(time.Now().Add(cfg.RequestTimeout))
+		tlsConn := tls.Client(conn, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         sni,
+			NextProtos:         []string{http2.NextProtoTLS},
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if negotiated := tlsConn.ConnectionState().NegotiatedProtocol; negotiated != http2.NextProtoTLS {
+			conn.Close()
+			return nil, fmt.Errorf("server does not support HTTP/2 (ALPN %q)", negotiated)
+		}
+		conn = tlsConn
+	}
+	return newH2Conn(conn, cfg, onClose)
+}
+
+// sendH2 sends the request prepared in req over HTTP/2
+func (s *Scanner) sendH2(protocol string, target Target, req *fasthttp.Request, resp *fasthttp.Response) error {
+	fields := s.h2Fields(protocol, target, req)
+	st, err := s.roundTripH2(protocol, target, fields)
+
+	// Like net/http, a request the server did not process is retried once.
+	// After a GOAWAY getH2Conn dials a new connection.
+	var unprocessed h2UnprocessedError
+	if errors.As(err, &unprocessed) {
+		st, err = s.roundTripH2(protocol, target, fields)
+	}
+	if err != nil {
+		return err
+	}
+	st.resp.copyTo(resp)
+	return nil
+}
+
+func (s *Scanner) roundTripH2(protocol string, target Target, fields []hpack.HeaderField) (*h2Stream, error) {
+	conn, err := s.clients.getH2Conn(protocol, target.address(protocol), s.sniName(target, protocol), s.config)
+	if err != nil {
+		return nil, err
+	}
+	return conn.roundTrip(fields, s.config.RequestTimeout)
+}
+
+// h2Fields returns the header fields of req, placing the candidate in
+// :authority and/or Host according to -h2-authority
+func (s *Scanner) h2Fields(protocol string, target Target, req *fasthttp.Request) []hpack.HeaderField {
+	candidate := string(req.Header.Host())
+	authority := candidate
+	if s.config.H2Authority == config.H2AuthorityIP {
+		authority = target.authority(protocol, target.IP)
+	}
+
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: string(req.Header.Method())},
+		{Name: ":scheme", Value: protocolScheme(protocol)},
+		{Name: ":authority", Value: authority},
+		{Name: ":path", Value: string(req.URI().RequestURI())},
+	}
+	if s.config.H2Authority != config.H2AuthorityHost {
+		fields = append(fields, hpack.HeaderField{Name: "host", Value: candidate})
+	}
+	req.Header.VisitAll(func(k, v []byte) {
+		name := strings.ToLower(string(k))
+		switch name {
+		case "host", "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			// Connection-specific headers are not allowed in HTTP/2
+			return
+		}
+		fields = append(fields, hpack.HeaderField{Name: name, Value: string(v)})
+	})
+	return fields
+}
@@ -0,0 +1,190 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// harvestCertificates connects to the HTTPS port of every endpoint in the IPs
+// file and collects the CN and SAN DNS names of the presented certificates,
+// both without SNI and with a random SNI name. Names already present in the
+// hosts file are dropped. The number of probed endpoints is returned too.
+// Harvesting stops early when ctx is cancelled.
+func (s *Scanner) harvestCertificates(ctx context.Context) ([]string, int64, error) {
+	ipFile, err := os.Open(s.config.IPsFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer ipFile.Close()
+
+	ipScanner := bufio.NewScanner(ipFile)
+	ipScanner.Buffer(make([]byte, bufferSize), bufferSize)
+	ips := newIPIterator(ipScanner)
+
+	names := make(map[string]bool)
+	var namesMu sync.Mutex
+	sem := make(chan struct{}, s.config.Concurrency)
+	var wg sync.WaitGroup
+	var endpoints int64
+
+	for ctx.Err() == nil {
+		ep, ok := ips.next()
+		if !ok {
+			break
+		}
+
+		for _, ep := range expandPorts([]endpoint{ep}, s.config.Ports) {
+			address := Target{IP: ep.IP, Port: ep.Port}.address("https")
+			endpoints++
+
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(address string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				found := s.certificateNames(address)
+				namesMu.Lock()
+				for _, name := range found {
+					names[name] = true
+				}
+				namesMu.Unlock()
+			}(address)
+		}
+	}
+	wg.Wait()
+
+	if err := ipScanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := removeKnownHosts(s.config.HostsFile, names); err != nil {
+		return nil, 0, err
+	}
+
+	harvested := make([]string, 0, len(names))
+	for name := range names {
+		harvested = append(harvested, name)
+	}
+	sort.Strings(harvested)
+	return harvested, endpoints, nil
+}
+
+// certificateNames returns the hostnames found in the certificates address
+// presents without SNI and with a random SNI name
+func (s *Scanner) certificateNames(address string) []string {
+	var names []string
+	for _, sni := range []string{"", randomHostname()} {
+		conn, err := s.tlsHandshake(address, sni)
+		if err != nil {
+			if s.config.Verbose {
+				fmt.Printf("[-] Certificate harvest failed for %s: %v\n", address, err)
+			}
+			// Servers that reject handshakes without SNI may answer the
+			// random name
+			continue
+		}
+
+		certs := conn.ConnectionState().PeerCertificates
+		conn.Close()
+		if len(certs) == 0 {
+			continue
+		}
+
+		candidates := append([]string{certs[0].Subject.CommonName}, certs[0].DNSNames...)
+		for _, candidate := range candidates {
+			if name, ok := normalizeCertificateName(candidate); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if s.config.Verbose && len(names) > 0 {
+		fmt.Printf("[*] Certificate names of %s: %s\n", address, strings.Join(names, ", "))
+	}
+	return names
+}
+
+// tlsHandshake connects to address and completes a TLS handshake with the
+// given SNI name, empty for none
+func (s *Scanner) tlsHandshake(address, sni string) (*tls.Conn, error) {
+	raw, err := s.clients.dialTimeout(address, s.config.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	raw.SetDeadline(time.Now().Add(s.config.RequestTimeout))
+
+	conn := tls.Client(raw, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         sni,
+	})
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// normalizeCertificateName turns a CN or SAN entry into a candidate hostname.
+// Wildcards are reduced to their parent domain, and IPs and free-form CNs
+// are rejected.
+func normalizeCertificateName(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	name = strings.TrimPrefix(name, "*.")
+	if !strings.Contains(name, ".") || net.ParseIP(name) != nil {
+		return "", false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_') {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// removeKnownHosts deletes every hostname of the hosts file from names
+func removeKnownHosts(hostsFile string, names map[string]bool) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(hostsFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, bufferSize), bufferSize)
+	for scanner.Scan() {
+		delete(names, strings.ToLower(strings.TrimSpace(scanner.Text())))
+	}
+	return scanner.Err()
+}
+
+func writeHostnames(path string, names []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(file)
+	for _, name := range names {
+		fmt.Fprintln(buf, name)
+	}
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
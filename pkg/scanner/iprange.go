@@ -0,0 +1,204 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// maxEntrySize caps the number of addresses a single CIDR or range may expand
+// to, which allows every IPv4 range but rejects huge IPv6 networks.
+const maxEntrySize = 1 << 32
+
+// ipEntry is a single line of the IPs file. It is either an address range
+// (a single address, a CIDR or a dash range) or an opaque host that is used
+// as-is.
+type ipEntry struct {
+	first  netip.Addr
+	last   netip.Addr
+	port   int    // 0 when the line has no explicit port
+	opaque string // Non-IP entries such as hostnames
+}
+
+// parseIPEntry understands "1.2.3.4", "2001:db8::1", "10.0.0.0/22",
+// "10.0.0.1-10.0.0.50", "10.0.0.1-50", "1.2.3.4:8080" and "[2001:db8::1]:8443".
+// Anything else that does not look like an IP is kept as an opaque entry.
+func parseIPEntry(line string) (ipEntry, error) {
+	if prefix, err := netip.ParsePrefix(line); err == nil {
+		prefix = prefix.Masked()
+		entry := ipEntry{first: prefix.Addr(), last: lastAddr(prefix)}
+		return entry, entry.validate()
+	}
+
+	if from, to, ok := strings.Cut(line, "-"); ok {
+		first, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err == nil {
+			last, err := parseRangeEnd(first, strings.TrimSpace(to))
+			if err != nil {
+				return ipEntry{}, err
+			}
+			entry := ipEntry{first: first, last: last}
+			return entry, entry.validate()
+		}
+	}
+
+	if addr, err := netip.ParseAddr(line); err == nil {
+		return ipEntry{first: addr, last: addr}, nil
+	}
+
+	if addrPort, err := netip.ParseAddrPort(line); err == nil {
+		addr := addrPort.Addr().Unmap()
+		return ipEntry{first: addr, last: addr, port: int(addrPort.Port())}, nil
+	}
+
+	if strings.ContainsAny(line, "/[] ") {
+		return ipEntry{}, fmt.Errorf("invalid IP, CIDR or range: %s", line)
+	}
+	if host, portStr, err := net.SplitHostPort(line); err == nil {
+		port, err := parsePort(portStr)
+		if err != nil {
+			return ipEntry{}, err
+		}
+		return ipEntry{opaque: host, port: port}, nil
+	}
+	return ipEntry{opaque: line}, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %s", s)
+	}
+	return port, nil
+}
+
+// parseRangeEnd parses the end of a dash range, which is either a full
+// address or, for IPv4, just the last octet.
+func parseRangeEnd(first netip.Addr, to string) (netip.Addr, error) {
+	if last, err := netip.ParseAddr(to); err == nil {
+		return last, nil
+	}
+
+	octet, err := strconv.Atoi(to)
+	if err != nil || !first.Is4() || octet < 0 || octet > 255 {
+		return netip.Addr{}, fmt.Errorf("invalid range end: %s", to)
+	}
+	b := first.As4()
+	b[3] = byte(octet)
+	return netip.AddrFrom4(b), nil
+}
+
+func (e ipEntry) validate() error {
+	if e.first.Is4() != e.last.Is4() {
+		return fmt.Errorf("range mixes IPv4 and IPv6: %s-%s", e.first, e.last)
+	}
+	if e.last.Less(e.first) {
+		return fmt.Errorf("range end is before its start: %s-%s", e.first, e.last)
+	}
+	if e.size() > maxEntrySize {
+		return fmt.Errorf("range %s-%s is too large", e.first, e.last)
+	}
+	return nil
+}
+
+// size returns the number of addresses in the entry, saturating above
+// maxEntrySize
+func (e ipEntry) size() uint64 {
+	if e.opaque != "" {
+		return 1
+	}
+
+	fb, lb := e.first.As16(), e.last.As16()
+	fhi, flo := binary.BigEndian.Uint64(fb[:8]), binary.BigEndian.Uint64(fb[8:])
+	lhi, llo := binary.BigEndian.Uint64(lb[:8]), binary.BigEndian.Uint64(lb[8:])
+
+	hi := lhi - fhi
+	if llo < flo {
+		hi--
+	}
+	if hi != 0 {
+		return maxEntrySize + 1
+	}
+	return llo - flo + 1
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	for i := bits; i < 128; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+
+	addr := netip.AddrFrom16(b)
+	if prefix.Addr().Is4() {
+		return addr.Unmap()
+	}
+	return addr
+}
+
+// ipIterator lazily expands the entries of the IPs file, so even large
+// CIDRs never have to be held in memory.
+type ipIterator struct {
+	scanner *bufio.Scanner
+	entry   ipEntry
+	current netip.Addr
+	pending bool // Whether entry still has addresses left
+}
+
+func newIPIterator(scanner *bufio.Scanner) *ipIterator {
+	return &ipIterator{scanner: scanner}
+}
+
+// next returns the next endpoint, or false once the file is exhausted. The
+// port is 0 unless the line specified one.
+func (it *ipIterator) next() (endpoint, bool) {
+	for !it.pending {
+		if !it.scanner.Scan() {
+			return endpoint{}, false
+		}
+		line := strings.TrimSpace(it.scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry, err := parseIPEntry(line)
+		if err != nil {
+			continue
+		}
+		it.entry = entry
+		it.current = entry.first
+		it.pending = true
+	}
+
+	if it.entry.opaque != "" {
+		it.pending = false
+		return endpoint{IP: it.entry.opaque, Port: it.entry.port}, true
+	}
+
+	addr := it.current
+	if addr == it.entry.last {
+		it.pending = false
+	} else {
+		it.current = addr.Next()
+	}
+	return endpoint{IP: addr.String(), Port: it.entry.port}, true
+}
+
+// readChunk returns up to chunkSize expanded endpoints
+func (it *ipIterator) readChunk(chunkSize int) ([]endpoint, error) {
+	var endpoints []endpoint
+	for len(endpoints) < chunkSize {
+		ep, ok := it.next()
+		if !ok {
+			break
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, it.scanner.Err()
+}
@@ -0,0 +1,222 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+// Response is the view of a response that matchers inspect
+type Response struct {
+	StatusCode   int
+	Header       *fasthttp.ResponseHeader
+	Body         []byte
+	Title        string
+	Elapsed      time.Duration
+	Location     string // Resolved Location the target redirects to
+	RedirectType string // Class of the redirect, see classifyRedirect
+
+	words, lines int // Computed on first use, -1 until then
+	json         interface{}
+	jsonDecoded  bool
+}
+
+func newResponse(resp *fasthttp.Response, info fetchInfo, title string, elapsed time.Duration) *Response {
+	return &Response{
+		StatusCode:   resp.StatusCode(),
+		Header:       &resp.Header,
+		Body:         resp.Body(),
+		Title:        title,
+		Elapsed:      elapsed,
+		Location:     info.Location,
+		RedirectType: info.RedirectType,
+		words:        -1,
+		lines:        -1,
+	}
+}
+
+// Words returns the number of whitespace-separated words in the body
+func (r *Response) Words() int {
+	if r.words < 0 {
+		r.words = countWords(r.Body)
+	}
+	return r.words
+}
+
+// Lines returns the number of lines in the body
+func (r *Response) Lines() int {
+	if r.lines < 0 {
+		r.lines = countLines(r.Body)
+	}
+	return r.lines
+}
+
+// JSON returns the decoded body, or false if it is not valid JSON
+func (r *Response) JSON() (interface{}, bool) {
+	if !r.jsonDecoded {
+		r.jsonDecoded = true
+		if err := json.Unmarshal(r.Body, &r.json); err != nil {
+			r.json = nil
+		}
+	}
+	return r.json, r.json != nil
+}
+
+// Matcher reports whether a response meets a condition. Every matcher can be
+// used to select responses (-m* flags) or to drop them (-f* flags).
+type Matcher interface {
+	Match(resp *Response) bool
+}
+
+// MatcherFunc adapts a function to the Matcher interface
+type MatcherFunc func(resp *Response) bool
+
+func (f MatcherFunc) Match(resp *Response) bool {
+	return f(resp)
+}
+
+// newMatcher builds the matcher of a match rule. Rules are validated when the
+// flags are parsed, an unknown kind is a programming error.
+func newMatcher(rule config.MatchRule) Matcher {
+	switch rule.Kind {
+	case config.MatchStatus:
+		return rangeMatcher(rule.Ranges, func(r *Response) int64 { return int64(r.StatusCode) })
+	case config.MatchWords:
+		return rangeMatcher(rule.Ranges, func(r *Response) int64 { return int64(r.Words()) })
+	case config.MatchLines:
+		return rangeMatcher(rule.Ranges, func(r *Response) int64 { return int64(r.Lines()) })
+	case config.MatchSize:
+		return rangeMatcher(rule.Ranges, func(r *Response) int64 { return int64(len(r.Body)) })
+	case config.MatchTime:
+		return rangeMatcher(rule.Ranges, func(r *Response) int64 { return r.Elapsed.Milliseconds() })
+	case config.MatchRegex:
+		return &regexMatcher{pattern: rule.Pattern}
+	case config.MatchTitle:
+		return &titleMatcher{pattern: rule.Pattern}
+	case config.MatchRedirect:
+		return &redirectMatcher{pattern: rule.Pattern}
+	case config.MatchHeader:
+		return &headerMatcher{name: rule.Header, pattern: rule.Pattern}
+	case config.MatchContentType:
+		return &contentTypeMatcher{types: rule.Values}
+	}
+	panic("unknown match rule kind: " + rule.Kind)
+}
+
+// rangeMatcher matches responses whose value lies within any of the ranges
+func rangeMatcher(ranges []config.Range, value func(*Response) int64) Matcher {
+	return MatcherFunc(func(resp *Response) bool {
+		v := value(resp)
+		for _, r := range ranges {
+			if v >= r.Min && v <= r.Max {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+type regexMatcher struct {
+	pattern *regexp.Regexp
+}
+
+func (m *regexMatcher) Match(resp *Response) bool {
+	return m.pattern.Match(resp.Body)
+}
+
+type redirectMatcher struct {
+	pattern *regexp.Regexp
+}
+
+func (m *redirectMatcher) Match(resp *Response) bool {
+	return resp.Location != "" && m.pattern.MatchString(resp.Location)
+}
+
+type titleMatcher struct {
+	pattern *regexp.Regexp
+}
+
+func (m *titleMatcher) Match(resp *Response) bool {
+	return m.pattern.MatchString(resp.Title)
+}
+
+// headerMatcher matches responses carrying the header, optionally with a value
+// matching pattern
+type headerMatcher struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func (m *headerMatcher) Match(resp *Response) bool {
+	found := false
+	resp.Header.VisitAll(func(key, value []byte) {
+		if !found && strings.EqualFold(string(key), m.name) {
+			found = m.pattern == nil || m.pattern.Match(value)
+		}
+	})
+	return found
+}
+
+type contentTypeMatcher struct {
+	types []string
+}
+
+func (m *contentTypeMatcher) Match(resp *Response) bool {
+	contentType := bytes.ToLower(resp.Header.ContentType())
+	for _, t := range m.types {
+		if bytes.Contains(contentType, []byte(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matcherSet decides which responses are reported. A response is reported if
+// it meets all matchers (any matcher unless all is set) and none of the
+// filters.
+type matcherSet struct {
+	matchers []Matcher
+	filters  []Matcher
+	all      bool
+}
+
+func newMatcherSet(rules []config.MatchRule, all bool) *matcherSet {
+	ms := &matcherSet{all: all}
+	for _, rule := range rules {
+		ms.add(newMatcher(rule), rule.Filter)
+	}
+	return ms
+}
+
+// add registers an additional matcher or filter
+func (ms *matcherSet) add(m Matcher, filter bool) {
+	if filter {
+		ms.filters = append(ms.filters, m)
+	} else {
+		ms.matchers = append(ms.matchers, m)
+	}
+}
+
+func (ms *matcherSet) accept(resp *Response) bool {
+	for _, f := range ms.filters {
+		if f.Match(resp) {
+			return false
+		}
+	}
+	if len(ms.matchers) == 0 {
+		return true
+	}
+
+	for _, m := range ms.matchers {
+		if m.Match(resp) != ms.all {
+			// A failed matcher decides with all set, a successful one otherwise
+			return !ms.all
+		}
+	}
+	return ms.all
+}
@@ -0,0 +1,320 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// outputExtensions maps each output format to the file extension used when
+// several formats are written at once
+var outputExtensions = map[string]string{
+	"jsonl": ".jsonl",
+	"csv":   ".csv",
+	"md":    ".md",
+	"html":  ".html",
+}
+
+// ResultWriter persists results in a specific output format
+type ResultWriter interface {
+	Write(result Result) error
+	Flush() error // Persist everything written so far, used for checkpoints
+	Close() error
+}
+
+// outputPath returns the file a format is written to. With more than one
+// format, the extension of outputFile is replaced per format.
+func outputPath(outputFile, format string, formats []string) string {
+	if len(formats) > 1 {
+		return strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + outputExtensions[format]
+	}
+	return outputFile
+}
+
+// newResultWriters opens one writer per configured output format. When
+// resuming, line based formats are appended to and the report formats are
+// rebuilt from the JSON Lines output if there is one.
+func newResultWriters(outputFile string, formats []string, resume bool) ([]ResultWriter, error) {
+	if outputFile == "" {
+		return nil, nil
+	}
+
+	var previous []Result
+	if resume {
+		for _, format := range formats {
+			if format != "jsonl" {
+				continue
+			}
+			results, err := readJSONL(outputPath(outputFile, format, formats))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			previous = results
+		}
+	}
+
+	var writers []ResultWriter
+	for _, format := range formats {
+		var writer ResultWriter
+		var err error
+
+		path := outputPath(outputFile, format, formats)
+
+		switch format {
+		case "jsonl":
+			writer, err = newJSONLWriter(path, resume)
+		case "csv":
+			writer, err = newCSVWriter(path, resume)
+		case "md":
+			var w *markdownWriter
+			if w, err = newMarkdownWriter(path); err == nil {
+				w.addAll(previous)
+				writer = w
+			}
+		case "html":
+			var w *htmlWriter
+			if w, err = newHTMLWriter(path); err == nil {
+				w.addAll(previous)
+				writer = w
+			}
+		default:
+			err = fmt.Errorf("unsupported output format: %s", format)
+		}
+
+		if err != nil {
+			for _, w := range writers {
+				w.Close()
+			}
+			return nil, err
+		}
+		writers = append(writers, writer)
+	}
+	return writers, nil
+}
+
+type jsonlWriter struct {
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// openOutput creates path, or opens it for appending when resuming
+func openOutput(path string, resume bool) (*os.File, error) {
+	if !resume {
+		return os.Create(path)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := trimPartialLine(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// trimPartialLine cuts off an incomplete last line left behind by a crash and
+// positions file at its end, so appended records start on a fresh line
+func trimPartialLine(file *os.File) error {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	size := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	_, err = file.Seek(size, io.SeekStart)
+	return err
+}
+
+// readJSONL loads the results of a JSON Lines output
+func readJSONL(path string) ([]Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var results []Result
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var result Result
+		if err := decoder.Decode(&result); err != nil {
+			// A crash may leave a partial last line behind
+			break
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func newJSONLWriter(path string, resume bool) (*jsonlWriter, error) {
+	file, err := openOutput(path, resume)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(file)
+	return &jsonlWriter{
+		file:    file,
+		buf:     buf,
+		encoder: json.NewEncoder(buf),
+	}, nil
+}
+
+func (w *jsonlWriter) Write(result Result) error {
+	return w.encoder.Encode(result)
+}
+
+func (w *jsonlWriter) Flush() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *jsonlWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+type csvWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newCSVWriter(path string, resume bool) (*csvWriter, error) {
+	file, err := openOutput(path, resume)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		header := make([]string, len(resultColumns))
+		for i, column := range resultColumns {
+			header[i] = column.Name
+		}
+		if err := writer.Write(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &csvWriter{
+		file:   file,
+		writer: writer,
+	}, nil
+}
+
+func (w *csvWriter) Write(result Result) error {
+	return w.writer.Write(resultCells(result))
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// formatExtracted renders extracted values as name=value1|value2; sorted by name
+func formatExtracted(extracted map[string][]string) string {
+	names := make([]string, 0, len(extracted))
+	for name := range extracted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + strings.Join(extracted[name], "|")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// formatRedirects renders a redirect chain as status location -> ...
+func formatRedirects(hops []RedirectHop) string {
+	parts := make([]string, len(hops))
+	for i, hop := range hops {
+		parts[i] = strconv.Itoa(hop.Status) + " " + hop.Location
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// formatLocation renders the redirect destination with its class
+func formatLocation(result Result) string {
+	if result.RedirectType == "" {
+		return result.Location
+	}
+	return result.Location + " (" + result.RedirectType + ")"
+}
+
+// formatLength renders the body length, with the declared Content-Length if
+// the two differ
+func formatLength(result Result) string {
+	if result.LengthMismatch {
+		return fmt.Sprintf("%d (declared %d)", result.BodyLength, result.ContentLength)
+	}
+	return strconv.Itoa(result.BodyLength)
+}
+
+// formatResult renders a result as the colored line printed to the terminal
+func formatResult(result Result) string {
+	similarity := "n/a"
+	if result.Similarity != nil {
+		similarity = fmt.Sprintf("%d%%", *result.Similarity)
+	}
+
+	details := ""
+	if result.Location != "" {
+		details += fmt.Sprintf(", Location: %s%s%s", colorPurple, formatLocation(result), colorReset)
+	}
+	if len(result.Redirects) > 0 {
+		details += fmt.Sprintf(", Redirects: %s%s%s", colorPurple, formatRedirects(result.Redirects), colorReset)
+	}
+	if len(result.Extracted) > 0 {
+		details += fmt.Sprintf(", Extracted: %s%s%s", colorYellow, formatExtracted(result.Extracted), colorReset)
+	}
+
+	// Decorate the output with colors and bold text
+	return fmt.Sprintf(
+		"\n %s[+] Found match - IP: %s%s, Host: %s%s, Path: %s%s, Status: %s%d%s, Size: %s%s%s, Words: %d, Lines: %d, Title: %s%s%s, Similarity: %s%s%s%s",
+		boldText+colorGreen,
+		colorCyan, net.JoinHostPort(result.IP, strconv.Itoa(result.Port)),
+		colorYellow, result.Host,
+		colorPurple, result.Path,
+		colorBlue, result.StatusCode, colorReset,
+		colorRed, formatLength(result), colorReset,
+		result.Words, result.Lines,
+		colorWhite, result.Title, colorReset,
+		colorCyan, similarity, colorReset,
+		details,
+	)
+}
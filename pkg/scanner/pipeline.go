@@ -0,0 +1,278 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// pipelineJob is a request of a pipelined batch
+type pipelineJob struct {
+	target   Target
+	protocol string
+	index    int // Index of the target in the batch
+	req      *fasthttp.Request
+	resp     *fasthttp.Response
+	elapsed  time.Duration
+	err      error
+}
+
+// pipelineSupport remembers the addresses that broke a pipeline. Their
+// requests are sent one at a time for the rest of the scan.
+type pipelineSupport struct {
+	mu          sync.Mutex
+	unsupported map[string]bool
+}
+
+func newPipelineSupport() *pipelineSupport {
+	return &pipelineSupport{unsupported: make(map[string]bool)}
+}
+
+func (ps *pipelineSupport) supported(address string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return !ps.unsupported[address]
+}
+
+// disable marks address as not supporting pipelining and reports whether it
+// was supported until now
+func (ps *pipelineSupport) disable(address string) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.unsupported[address] {
+		return false
+	}
+	ps.unsupported[address] = true
+	return true
+}
+
+// pipelineWorker is the worker used with -pipeline. It takes as many queued
+// targets as the pipeline depth allows and pipelines the requests that go to
+// the same connection.
+func (wp *WorkerPool) pipelineWorker(ctx context.Context) {
+	defer wp.workerGroup.Done()
+
+	batch := make([]Target, 0, wp.scanner.config.Pipeline)
+	for target := range wp.scanner.targetChan {
+		batch = wp.fillBatch(append(batch[:0], target))
+		wp.processBatch(ctx, batch)
+	}
+}
+
+// fillBatch adds the targets that are already queued, without waiting for
+// more
+func (wp *WorkerPool) fillBatch(batch []Target) []Target {
+	for len(batch) < cap(batch) {
+		select {
+		case target, ok := <-wp.scanner.targetChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, target)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// processBatch is process for a batch of targets
+func (wp *WorkerPool) processBatch(ctx context.Context, targets []Target) {
+	s := wp.scanner
+	if s.discovered != nil {
+		defer func() {
+			for range targets {
+				s.discovered.targetDone()
+			}
+		}()
+	}
+
+	// Interrupted batches are dropped as a whole, see process
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Registered before the rate limiter can return early
+	var jobs []*pipelineJob
+	defer func() {
+		for _, job := range jobs {
+			fasthttp.ReleaseRequest(job.req)
+			fasthttp.ReleaseResponse(job.resp)
+		}
+	}()
+	for i, target := range targets {
+		if s.rateLimiter != nil {
+			if err := s.rateLimiter.Wait(ctx); err != nil {
+				return
+			}
+		}
+		for _, protocol := range s.protocolsFor(target) {
+			jobs = append(jobs, &pipelineJob{
+				target:   target,
+				protocol: protocol,
+				index:    i,
+				req:      fasthttp.AcquireRequest(),
+				resp:     fasthttp.AcquireResponse(),
+			})
+		}
+	}
+
+	wp.sendJobs(jobs)
+
+	results := make([][]Result, len(targets))
+	for _, job := range jobs {
+		s.requests.Add(1)
+		if job.err != nil {
+			s.requestErrors.Add(1)
+			continue
+		}
+
+		start := time.Now()
+		info := s.finishFetch(job.protocol, job.target, job.req, job.resp)
+		elapsed := job.elapsed + time.Since(start)
+		if result, ok := s.checkResponse(job.target, job.protocol, info, job.req, job.resp, elapsed); ok {
+			results[job.index] = append(results[job.index], result)
+		}
+	}
+	for i, target := range targets {
+		wp.finish(target, results[i])
+	}
+}
+
+// sendJobs sends the requests of jobs, pipelining those that share a
+// connection. Requests the pipeline did not answer are sent one at a time.
+func (wp *WorkerPool) sendJobs(jobs []*pipelineJob) {
+	s := wp.scanner
+
+	// Requests share a connection if they go to the same address with the
+	// same protocol and TLS server name
+	var keys []string
+	groups := make(map[string][]*pipelineJob)
+	for _, job := range jobs {
+		key := job.protocol + "://" + job.target.address(job.protocol) + "/" + s.sniName(job.target, job.protocol)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], job)
+	}
+
+	for _, key := range keys {
+		jobs := groups[key]
+		if isHTTP2(jobs[0].protocol) {
+			// HTTP/2 requests are sent concurrently as streams of one connection
+			var wg sync.WaitGroup
+			for _, job := range jobs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.sendJob(job)
+				}()
+			}
+			wg.Wait()
+			continue
+		}
+		for _, job := range wp.pipeline(jobs) {
+			s.sendJob(job)
+		}
+	}
+}
+
+// sendJob sends the request of job on its own
+func (s *Scanner) sendJob(job *pipelineJob) {
+	start := time.Now()
+	job.err = s.send(job.protocol, job.target, job.req, job.resp)
+	job.elapsed = time.Since(start)
+}
+
+// pipeline writes the HTTP/1.1 requests of jobs back-to-back on one
+// connection and reads the responses in order. It returns the jobs left
+// without a response. An address whose server stops answering without
+// closing the connection properly, or that only speaks HTTP/1.0, is marked
+// as not supporting pipelining.
+func (wp *WorkerPool) pipeline(jobs []*pipelineJob) []*pipelineJob {
+	s := wp.scanner
+	first := jobs[0]
+	address := first.target.address(first.protocol)
+	if len(jobs) < 2 || !wp.pipelines.supported(address) {
+		return jobs
+	}
+
+	conn, err := s.pipelineConn(first.protocol, address, s.sniName(first.target, first.protocol))
+	if err != nil {
+		return jobs
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetWriteDeadline(start.Add(s.config.WriteTimeout))
+	bw := bufio.NewWriter(conn)
+	for i, job := range jobs {
+		s.prepareRequest(job.protocol, job.target, job.req)
+		if i == len(jobs)-1 {
+			job.req.Header.SetConnectionClose()
+		}
+		if err := job.req.Write(bw); err != nil {
+			return jobs
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return jobs
+	}
+
+	// The server answers the requests one after the other, so the time of a
+	// response is measured from the end of the previous one
+	br := bufio.NewReader(conn)
+	for i, job := range jobs {
+		conn.SetReadDeadline(time.Now().Add(s.config.RequestTimeout))
+		job.resp.Reset()
+		err := job.resp.Read(br)
+		if err != nil && !truncatedBody(job.resp, err) {
+			if i > 0 {
+				wp.disablePipelining(address, err)
+			}
+			return jobs[i:]
+		}
+		now := time.Now()
+		job.elapsed = now.Sub(start)
+		start = now
+		if i == len(jobs)-1 {
+			break
+		}
+
+		switch {
+		case !job.resp.Header.IsHTTP11():
+			wp.disablePipelining(address, errors.New("HTTP/1.0 response"))
+			return jobs[i+1:]
+		case err != nil || job.resp.ConnectionClose():
+			// The connection ends with this response, the remaining requests
+			// go to a new one
+			conn.Close()
+			return wp.pipeline(jobs[i+1:])
+		}
+	}
+	return nil
+}
+
+func (wp *WorkerPool) disablePipelining(address string, err error) {
+	if wp.pipelines.disable(address) && wp.scanner.config.Verbose {
+		fmt.Printf("[-] Disabling pipelining for %s: %v\n", address, err)
+	}
+}
+
+// pipelineConn opens a connection to address for protocol
+func (s *Scanner) pipelineConn(protocol, address, sni string) (net.Conn, error) {
+	if protocol != "https" {
+		return s.clients.dialTimeout(address, s.config.RequestTimeout)
+	}
+	conn, err := s.tlsHandshake(address, sni)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,91 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	probeUnknown     = ""            // The endpoint could not be classified, try every protocol
+	probeUnreachable = "unreachable" // The endpoint refused the connection, skip it
+)
+
+// protocolProbe is the cached protocol detection result of one ip:port
+type protocolProbe struct {
+	once     sync.Once
+	protocol string
+}
+
+// detectProtocol probes address once and returns "http", "https",
+// probeUnreachable or probeUnknown. Concurrent callers share the probe.
+func (cc *clientCache) detectProtocol(address string, timeout time.Duration, verbose bool) string {
+	cc.mu.Lock()
+	probe, ok := cc.probes[address]
+	if !ok {
+		probe = &protocolProbe{}
+		cc.probes[address] = probe
+	}
+	cc.mu.Unlock()
+
+	probe.once.Do(func() {
+		probe.protocol = probeProtocol(cc, address, timeout)
+		if verbose {
+			protocol := probe.protocol
+			if protocol == probeUnknown {
+				protocol = "unknown"
+			}
+			fmt.Printf("[*] Detected protocol of %s: %s\n", address, protocol)
+		}
+	})
+	return probe.protocol
+}
+
+// probeProtocol attempts a TLS handshake with address. A completed handshake
+// or a TLS alert means https, a non-TLS first record means plain http.
+func probeProtocol(cc *clientCache, address string, timeout time.Duration) string {
+	conn, err := cc.dialTimeout(address, timeout)
+	if err != nil {
+		return probeUnreachable
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	err = tlsConn.Handshake()
+	if err == nil {
+		return "https"
+	}
+
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		return "http"
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return "https"
+	}
+
+	return probeUnknown
+}
+
+// protocolsFor returns the configured protocols that target should be
+// requested with, taking protocol detection into account
+func (s *Scanner) protocolsFor(target Target) []string {
+	if !s.config.DetectProtocol {
+		return s.config.Protocols
+	}
+
+	var protocols []string
+	for _, protocol := range s.config.Protocols {
+		detected := s.clients.detectProtocol(target.address(protocol), s.config.RequestTimeout, s.config.Verbose)
+		if detected == protocolScheme(protocol) || detected == probeUnknown {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols
+}
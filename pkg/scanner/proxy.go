@@ -0,0 +1,283 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+)
+
+var errNoProxies = errors.New("no healthy proxies left")
+
+// proxyPool hands out the configured proxies for new connections. A proxy
+// that cannot be reached maxFailures times in a row is retired, connections
+// then fail over to the next proxy.
+type proxyPool struct {
+	proxies     []*proxyEntry
+	sticky      bool // Pick the proxy by target IP instead of round-robin
+	maxFailures int32
+	next        atomic.Uint64
+	alive       atomic.Int32
+}
+
+type proxyEntry struct {
+	url      *url.URL
+	failures atomic.Int32
+	retired  atomic.Bool
+}
+
+func newProxyPool(cfg config.Config) *proxyPool {
+	if len(cfg.Proxies) == 0 {
+		return nil
+	}
+
+	pool := &proxyPool{
+		sticky:      cfg.ProxyRotation == config.ProxySticky,
+		maxFailures: int32(cfg.ProxyMaxFailures),
+	}
+	for _, u := range cfg.Proxies {
+		pool.proxies = append(pool.proxies, &proxyEntry{url: u})
+	}
+	pool.alive.Store(int32(len(pool.proxies)))
+	return pool
+}
+
+// dial connects to address through a proxy, trying the following proxies if
+// the picked one cannot be reached
+func (p *proxyPool) dial(address string, timeout time.Duration) (net.Conn, error) {
+	start := p.start(address)
+	for i := range p.proxies {
+		px := p.proxies[(start+i)%len(p.proxies)]
+		if px.retired.Load() {
+			continue
+		}
+
+		conn, err := px.dial(address, timeout)
+		var proxyErr *proxyError
+		if err == nil || !errors.As(err, &proxyErr) {
+			// The proxy works, even if the target does not
+			px.failures.Store(0)
+			return conn, err
+		}
+		p.fail(px, err)
+	}
+	return nil, errNoProxies
+}
+
+// start returns the index of the first proxy tried for address
+func (p *proxyPool) start(address string) int {
+	if !p.sticky {
+		return int(p.next.Add(1) % uint64(len(p.proxies)))
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return int(h.Sum32() % uint32(len(p.proxies)))
+}
+
+func (p *proxyPool) fail(px *proxyEntry, err error) {
+	if px.failures.Add(1) < p.maxFailures || !px.retired.CompareAndSwap(false, true) {
+		return
+	}
+
+	fmt.Printf("[-] Retiring proxy %s after %d failures: %v\n", px.url.Redacted(), p.maxFailures, err)
+	if p.alive.Add(-1) == 0 {
+		fmt.Printf("[-] All proxies have been retired, remaining requests will fail\n")
+	}
+}
+
+// proxyError is a failure of the proxy itself rather than of the target
+type proxyError struct {
+	err error
+}
+
+func (e *proxyError) Error() string { return e.err.Error() }
+func (e *proxyError) Unwrap() error { return e.err }
+
+// dial opens a tunnel to address through the proxy
+func (px *proxyEntry) dial(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", px.url.Host, timeout)
+	if err != nil {
+		return nil, &proxyError{err}
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	tunnel := conn
+	if px.url.Scheme == "http" {
+		tunnel, err = httpConnect(conn, px.url, address)
+	} else {
+		err = socks5Connect(conn, px.url, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// httpConnect opens a CONNECT tunnel. Plain HTTP requests are tunnelled too,
+// so the Host header reaches the target exactly as sent.
+func httpConnect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	request := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		return nil, &proxyError{err}
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, &proxyError{err}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, &proxyError{fmt.Errorf("proxy CONNECT to %s: %s", address, resp.Status)}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("proxy CONNECT to %s: %s", address, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn keeps bytes read past the CONNECT response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// socks5Replies are the SOCKS5 reply messages, see RFC 1928
+var socks5Replies = []string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect performs a SOCKS5 handshake with optional username and
+// password authentication and connects to address
+func socks5Connect(conn net.Conn, proxy *url.URL, address string) error {
+	method := byte(0x00) // No authentication
+	if proxy.User != nil {
+		method = 0x02 // Username and password
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return &proxyError{err}
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return &proxyError{err}
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return &proxyError{fmt.Errorf("socks5 proxy refused authentication method %d", method)}
+	}
+
+	if method == 0x02 {
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return &proxyError{errors.New("socks5 credentials too long")}
+		}
+		auth := append([]byte{0x01, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return &proxyError{err}
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return &proxyError{err}
+		}
+		if reply[1] != 0x00 {
+			return &proxyError{errors.New("socks5 authentication failed")}
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5 host name too long: %s", host)
+		}
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(append(request, 0x01), ip4...)
+	} else {
+		request = append(append(request, 0x04), ip...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return &proxyError{err}
+	}
+
+	// Reply: version, status, reserved, address type, bound address and port
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return &proxyError{err}
+	}
+	if status := int(header[1]); status != 0 {
+		message := "unknown error"
+		if status < len(socks5Replies) {
+			message = socks5Replies[status]
+		}
+		return fmt.Errorf("socks5 connect to %s: %s", address, message)
+	}
+
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return &proxyError{err}
+		}
+		skip = int(length[0])
+	default:
+		return &proxyError{fmt.Errorf("socks5 reply with unknown address type %d", header[3])}
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(skip+2)); err != nil {
+		return &proxyError{err}
+	}
+	return nil
+}
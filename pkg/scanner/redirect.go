@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+)
+
+// RedirectHop is a redirect response of a chain
+type RedirectHop struct {
+	Status   int    `json:"status"`
+	Location string `json:"location"` // Absolute URL the Location resolved to
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+// Redirect classes, relative to the requested host
+const (
+	redirectSameHost = "same-host"
+	redirectHTTPS    = "https" // Same host, upgraded to HTTPS
+	redirectOffHost  = "off-host"
+)
+
+// resolveLocation resolves location against the URL requested for target
+func resolveLocation(target Target, protocol, location string) (*url.URL, error) {
+	base, err := url.Parse(protocolScheme(protocol) + "://" + target.hostHeader(protocol, true) + target.Path)
+	if err != nil {
+		return nil, err
+	}
+	return base.Parse(strings.TrimSpace(location))
+}
+
+// classifyRedirect returns the resolved location and its redirect class. An
+// unresolvable location is returned as is, without a class.
+func classifyRedirect(target Target, protocol, location string) (string, string) {
+	dest, err := resolveLocation(target, protocol, location)
+	if err != nil || dest.Host == "" {
+		return location, ""
+	}
+
+	host := dest.Hostname()
+	switch {
+	case !strings.EqualFold(host, target.Hostname) && host != target.IP:
+		return dest.String(), redirectOffHost
+	case dest.Scheme == "https" && protocolScheme(protocol) == "http":
+		return dest.String(), redirectHTTPS
+	}
+	return dest.String(), redirectSameHost
+}
+
+// redirectTarget resolves location and returns the target and protocol of the
+// next hop, along with the resolved URL (or location if it cannot be
+// resolved). HTTP/2 is kept as long as the scheme does not change. It returns
+// false if the redirect must not be followed.
+func (s *Scanner) redirectTarget(target Target, protocol, location string) (Target, string, string, bool) {
+	dest, err := resolveLocation(target, protocol, location)
+	if err != nil || (dest.Scheme != "http" && dest.Scheme != "https") || dest.Host == "" {
+		return Target{}, "", location, false
+	}
+
+	next := target
+	next.Port = 0 // Default port of the new scheme
+	if port := dest.Port(); port != "" {
+		if next.Port, err = strconv.Atoi(port); err != nil {
+			return Target{}, "", dest.String(), false
+		}
+	}
+	next.Path = dest.RequestURI()
+	nextProtocol := dest.Scheme
+	if dest.Scheme == protocolScheme(protocol) {
+		nextProtocol = protocol
+	}
+
+	// Hops to the same host, or any hop when the candidate is kept, stay on the
+	// target IP
+	host := strings.ToLower(dest.Hostname())
+	if strings.EqualFold(host, target.Hostname) || host == target.IP || s.config.RedirectHost == config.RedirectHostFuzzed {
+		return next, nextProtocol, dest.String(), true
+	}
+
+	next.Hostname = host
+	if s.config.RedirectSameIP {
+		// An IP literal cannot be sent to another IP
+		if net.ParseIP(host) != nil {
+			return Target{}, "", dest.String(), false
+		}
+	} else {
+		next.IP = host
+	}
+	return next, nextProtocol, dest.String(), true
+}
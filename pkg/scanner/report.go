@@ -0,0 +1,240 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ipGroup holds all results of a single IP, in the order they were found
+type ipGroup struct {
+	IP      string
+	Results []Result
+}
+
+// reportCollector buffers results for formats that are rendered as a whole
+// once the scan has finished
+type reportCollector struct {
+	path   string
+	groups []*ipGroup
+	byIP   map[string]*ipGroup
+	total  int
+}
+
+func newReportCollector(path string) (*reportCollector, error) {
+	// Create the file right away so a bad path fails before scanning
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	return &reportCollector{
+		path: path,
+		byIP: make(map[string]*ipGroup),
+	}, nil
+}
+
+func (rc *reportCollector) add(result Result) {
+	group, ok := rc.byIP[result.IP]
+	if !ok {
+		group = &ipGroup{IP: result.IP}
+		rc.byIP[result.IP] = group
+		rc.groups = append(rc.groups, group)
+	}
+	group.Results = append(group.Results, result)
+	rc.total++
+}
+
+func (rc *reportCollector) addAll(results []Result) {
+	for _, result := range results {
+		rc.add(result)
+	}
+}
+
+// Flush is a no-op, reports are rendered as a whole on Close
+func (rc *reportCollector) Flush() error {
+	return nil
+}
+
+// statusSummary returns the number of results per status code
+func (rc *reportCollector) statusSummary() [][2]int {
+	counts := make(map[int]int)
+	for _, group := range rc.groups {
+		for _, result := range group.Results {
+			counts[result.StatusCode]++
+		}
+	}
+
+	summary := make([][2]int, 0, len(counts))
+	for status, count := range counts {
+		summary = append(summary, [2]int{status, count})
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i][0] < summary[j][0] })
+	return summary
+}
+
+type markdownWriter struct {
+	*reportCollector
+}
+
+func newMarkdownWriter(path string) (*markdownWriter, error) {
+	rc, err := newReportCollector(path)
+	if err != nil {
+		return nil, err
+	}
+	return &markdownWriter{rc}, nil
+}
+
+func (w *markdownWriter) Write(result Result) error {
+	w.add(result)
+	return nil
+}
+
+func (w *markdownWriter) Close() error {
+	file, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+
+	fmt.Fprintf(buf, "# vhost-fuzzer results\n\n")
+	fmt.Fprintf(buf, "Generated %s: %d findings on %d IPs.\n\n", time.Now().UTC().Format(time.RFC3339), w.total, len(w.groups))
+
+	if w.total > 0 {
+		fmt.Fprintf(buf, "| Status | Findings |\n|---|---|\n")
+		for _, entry := range w.statusSummary() {
+			fmt.Fprintf(buf, "| %d | %d |\n", entry[0], entry[1])
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+
+	for _, group := range w.groups {
+		fmt.Fprintf(buf, "## %s\n\n", group.IP)
+		for _, column := range resultColumns {
+			fmt.Fprintf(buf, "| %s ", column.Title)
+		}
+		fmt.Fprintf(buf, "|\n%s|\n", strings.Repeat("|---", len(resultColumns)))
+		for _, result := range group.Results {
+			for _, cell := range resultCells(result) {
+				fmt.Fprintf(buf, "| %s ", markdownEscape(cell))
+			}
+			fmt.Fprintf(buf, "|\n")
+		}
+		fmt.Fprintf(buf, "\n")
+	}
+
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func markdownEscape(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	s = strings.ReplaceAll(s, "\r", " ")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+type htmlWriter struct {
+	*reportCollector
+}
+
+func newHTMLWriter(path string) (*htmlWriter, error) {
+	rc, err := newReportCollector(path)
+	if err != nil {
+		return nil, err
+	}
+	return &htmlWriter{rc}, nil
+}
+
+func (w *htmlWriter) Write(result Result) error {
+	w.add(result)
+	return nil
+}
+
+func (w *htmlWriter) Close() error {
+	file, err := os.Create(w.path)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+
+	err = htmlReport.Execute(buf, map[string]interface{}{
+		"Generated": time.Now().UTC().Format(time.RFC3339),
+		"Total":     w.total,
+		"Groups":    w.groups,
+		"Summary":   w.statusSummary(),
+		"Columns":   resultColumns,
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{"cells": resultCells}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>vhost-fuzzer results</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; cursor: pointer; user-select: none; }
+th:after { content: " \21C5"; color: #999; }
+pre { white-space: pre-wrap; word-break: break-all; max-height: 20em; overflow: auto; background: #f7f7f7; padding: 4px; }
+.s2 { color: #080; } .s3 { color: #06c; } .s4 { color: #c60; } .s5 { color: #c00; }
+</style>
+</head>
+<body>
+<h1>vhost-fuzzer results</h1>
+<p>Generated {{.Generated}}: {{.Total}} findings on {{len .Groups}} IPs.</p>
+{{if .Summary}}<p>{{range .Summary}}<span class="s{{slice (printf "%d" (index . 0)) 0 1}}">{{index . 0}}</span>: {{index . 1}} &nbsp; {{end}}</p>{{end}}
+{{range .Groups}}
+<h2>{{.IP}}</h2>
+<table class="sortable">
+<thead><tr>{{range $.Columns}}<th>{{.Title}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Results}}<tr>
+{{range $i, $cell := cells .}}{{with index $.Columns $i}}{{if eq .Name "status"}}<td class="s{{slice $cell 0 1}}">{{$cell}}</td>
+{{else if eq .Name "snippet"}}<td>{{if $cell}}<details><summary>{{len $cell}} bytes</summary><pre>{{$cell}}</pre></details>{{end}}</td>
+{{else}}<td>{{$cell}}</td>
+{{end}}{{end}}{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{end}}
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  table.querySelectorAll("th").forEach(function (th, column) {
+    th.addEventListener("click", function () {
+      var tbody = table.tBodies[0];
+      var asc = th.dataset.order !== "asc";
+      th.dataset.order = asc ? "asc" : "desc";
+      var rows = Array.prototype.slice.call(tbody.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[column].innerText, y = b.cells[column].innerText;
+        var nx = parseFloat(x), ny = parseFloat(y);
+        var cmp = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+        return asc ? cmp : -cmp;
+      });
+      rows.forEach(function (row) { tbody.appendChild(row); });
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+)
+
+// dnsTimeout limits a lookup against a single DNS server
+const dnsTimeout = 5 * time.Second
+
+// resolver looks up the addresses of hostnames connections go to. IP
+// literals never cause a lookup, -dns-hosts entries take precedence over DNS
+// and the -resolvers servers are used round-robin, failing over to the next
+// server when one does not answer. It implements fasthttp.Resolver.
+type resolver struct {
+	overrides map[string][]net.IP
+	servers   []*net.Resolver // nil for the system resolver
+	next      atomic.Uint64
+}
+
+func newResolver(cfg config.Config) *resolver {
+	r := &resolver{overrides: cfg.DNSHosts}
+	for _, server := range cfg.Resolvers {
+		r.servers = append(r.servers, &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dnsTimeout}
+				return d.DialContext(ctx, network, server)
+			},
+		})
+	}
+	return r
+}
+
+func (r *resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	if ips, ok := r.overrides[strings.TrimSuffix(strings.ToLower(host), ".")]; ok {
+		addrs := make([]net.IPAddr, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.IPAddr{IP: ip}
+		}
+		return addrs, nil
+	}
+	if len(r.servers) == 0 {
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+
+	var err error
+	start := int(r.next.Add(1) % uint64(len(r.servers)))
+	for i := range r.servers {
+		server := r.servers[(start+i)%len(r.servers)]
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		var addrs []net.IPAddr
+		addrs, err = server.LookupIPAddr(lookupCtx, host)
+		cancel()
+
+		// A name that does not exist is an answer, only failures move on
+		var dnsErr *net.DNSError
+		if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) || ctx.Err() != nil {
+			return addrs, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// reportedHeaders are the response headers copied into every result
+var reportedHeaders = []string{
+	"Server",
+	"Content-Type",
+	"Location",
+	"X-Powered-By",
+	"Via",
+	"WWW-Authenticate",
+}
+
+// Result is a single finding produced by checkTarget. It is the unit consumed
+// by all output formatters.
+type Result struct {
+	IP             string              `json:"ip"`
+	Port           int                 `json:"port"`
+	Protocol       string              `json:"protocol"`
+	Host           string              `json:"host"`
+	Path           string              `json:"path"`
+	URL            string              `json:"url"`
+	SNI            string              `json:"sni,omitempty"`
+	StatusCode     int                 `json:"status"`
+	ContentLength  int                 `json:"content_length"`             // Declared by the server, -1 if absent
+	BodyLength     int                 `json:"body_length"`                // Decoded body length
+	WireLength     int                 `json:"wire_length"`                // Body length as received
+	Encoding       string              `json:"content_encoding,omitempty"` // Content-Encoding that was decoded
+	LengthMismatch bool                `json:"length_mismatch,omitempty"`  // Content-Length differs from the received body
+	Words          int                 `json:"words"`
+	Lines          int                 `json:"lines"`
+	Title          string              `json:"title"`
+	Generator      string              `json:"generator,omitempty"` // <meta name="generator">
+	OGTitle        string              `json:"og_title,omitempty"`
+	H1             string              `json:"h1,omitempty"` // Text of the first <h1>
+	ResponseTimeMs int64               `json:"response_time_ms"`
+	Headers        map[string]string   `json:"headers,omitempty"`
+	BodyHash       string              `json:"body_hash"`
+	Similarity     *int                `json:"similarity,omitempty"`    // Percent similar to the default vhost
+	Location       string              `json:"location,omitempty"`      // Resolved Location if the target redirects
+	RedirectType   string              `json:"redirect_type,omitempty"` // same-host, https or off-host
+	Redirects      []RedirectHop       `json:"redirects,omitempty"`     // Redirect responses with the resolved Location
+	Depth          int                 `json:"depth,omitempty"`         // Recursion depth of the host
+	Extracted      map[string][]string `json:"extracted,omitempty"`     // Values captured by the extractors
+	Snippet        string              `json:"snippet,omitempty"`       // Beginning of the response body
+	Timestamp      time.Time           `json:"timestamp"`
+}
+
+// resultColumn is a column of the CSV, Markdown and HTML outputs
+type resultColumn struct {
+	Name  string // CSV header
+	Title string // Report table header
+	Value func(Result) string
+}
+
+// resultColumns are the columns of all tabular outputs, in order
+var resultColumns = []resultColumn{
+	{"ip", "IP", func(r Result) string { return r.IP }},
+	{"port", "Port", func(r Result) string { return strconv.Itoa(r.Port) }},
+	{"protocol", "Protocol", func(r Result) string { return r.Protocol }},
+	{"host", "Host", func(r Result) string { return r.Host }},
+	{"path", "Path", func(r Result) string { return r.Path }},
+	{"url", "URL", func(r Result) string { return r.URL }},
+	{"sni", "SNI", func(r Result) string { return r.SNI }},
+	{"status", "Status", func(r Result) string { return strconv.Itoa(r.StatusCode) }},
+	{"content_length", "Content-Length", func(r Result) string { return strconv.Itoa(r.ContentLength) }},
+	{"body_length", "Body Length", func(r Result) string { return strconv.Itoa(r.BodyLength) }},
+	{"wire_length", "Wire Length", func(r Result) string { return strconv.Itoa(r.WireLength) }},
+	{"content_encoding", "Encoding", func(r Result) string { return r.Encoding }},
+	{"length_mismatch", "Length Mismatch", func(r Result) string { return strconv.FormatBool(r.LengthMismatch) }},
+	{"words", "Words", func(r Result) string { return strconv.Itoa(r.Words) }},
+	{"lines", "Lines", func(r Result) string { return strconv.Itoa(r.Lines) }},
+	{"title", "Title", func(r Result) string { return r.Title }},
+	{"generator", "Generator", func(r Result) string { return r.Generator }},
+	{"og_title", "OG Title", func(r Result) string { return r.OGTitle }},
+	{"h1", "H1", func(r Result) string { return r.H1 }},
+	{"server", "Server", func(r Result) string { return r.Headers["Server"] }},
+	{"content_type", "Content-Type", func(r Result) string { return r.Headers["Content-Type"] }},
+	{"location", "Location", func(r Result) string { return r.Location }},
+	{"redirect_type", "Redirect Type", func(r Result) string { return r.RedirectType }},
+	{"redirects", "Redirects", func(r Result) string { return formatRedirects(r.Redirects) }},
+	{"similarity", "Similarity", func(r Result) string {
+		if r.Similarity == nil {
+			return ""
+		}
+		return strconv.Itoa(*r.Similarity)
+	}},
+	{"response_time_ms", "Time (ms)", func(r Result) string { return strconv.FormatInt(r.ResponseTimeMs, 10) }},
+	{"body_hash", "Body Hash", func(r Result) string { return r.BodyHash }},
+	{"extracted", "Extracted", func(r Result) string { return formatExtracted(r.Extracted) }},
+	{"snippet", "Response", func(r Result) string { return r.Snippet }},
+	{"timestamp", "Timestamp", func(r Result) string { return r.Timestamp.Format(time.RFC3339) }},
+}
+
+// resultCells returns the values of result for resultColumns
+func resultCells(result Result) []string {
+	cells := make([]string, len(resultColumns))
+	for i, column := range resultColumns {
+		cells[i] = column.Value(result)
+	}
+	return cells
+}
+
+// snippetSize is the maximum number of body bytes kept in Result.Snippet
+const snippetSize = 1024
+
+// newResult copies everything needed from resp, which is reused by the worker
+// once the result has been built.
+func newResult(target Target, protocol string, info fetchInfo, resp *fasthttp.Response, elapsed time.Duration, meta pageMetadata) Result {
+	body := resp.Body()
+	bodyHash := sha256.Sum256(body)
+
+	// fasthttp reports the received length for bodies delimited by the
+	// connection closing, so only chunked and HTTP/2 responses can lack one
+	contentLength := -1
+	if declared := resp.Header.Peek("Content-Length"); len(declared) > 0 {
+		if n, err := strconv.Atoi(string(declared)); err == nil {
+			contentLength = n
+		}
+	}
+
+	headers := make(map[string]string)
+	for _, name := range reportedHeaders {
+		if value := resp.Header.Peek(name); len(value) > 0 {
+			headers[name] = string(value)
+		}
+	}
+
+	return Result{
+		IP:             target.IP,
+		Port:           target.effectivePort(protocol),
+		Protocol:       protocol,
+		Host:           target.Hostname,
+		Path:           target.Path,
+		URL:            info.URI,
+		StatusCode:     resp.StatusCode(),
+		ContentLength:  contentLength,
+		BodyLength:     len(body),
+		WireLength:     info.WireLength,
+		LengthMismatch: lengthMismatch(resp.StatusCode(), contentLength, info.WireLength),
+		Encoding:       info.Encoding,
+		Location:       info.Location,
+		RedirectType:   info.RedirectType,
+		Redirects:      info.Redirects,
+		Title:          meta.Title,
+		Generator:      meta.Generator,
+		OGTitle:        meta.OGTitle,
+		H1:             meta.H1,
+		ResponseTimeMs: elapsed.Milliseconds(),
+		Headers:        headers,
+		BodyHash:       hex.EncodeToString(bodyHash[:]),
+		Snippet:        truncateString(string(body), snippetSize),
+		Timestamp:      time.Now().UTC(),
+	}
+}
+
+// lengthMismatch reports whether a declared Content-Length disagrees with the
+// body length received. Responses that never carry a body are ignored.
+func lengthMismatch(statusCode, contentLength, wireLength int) bool {
+	if contentLength < 0 || statusCode < 200 || statusCode == 204 || statusCode == 304 {
+		return false
+	}
+	return contentLength != wireLength
+}
@@ -1,8 +1,11 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dsecuredcom/vhost-fuzzer/pkg/config"
@@ -14,12 +17,24 @@ type Scanner struct {
 	config         config.Config
 	bar            *progressbar.ProgressBar
 	targetChan     chan Target
-	resultChan     chan string
+	resultChan     chan Result
 	clients        *clientCache
 	progressCount  int64
 	progressMutex  sync.Mutex
 	lastUpdateTime time.Time
 	rateLimiter    *rate.Limiter
+	baselines      *baselineCache
+	discovered     *hostQueue
+	scopePattern   *regexp.Regexp
+	matchers       *matcherSet
+	extractors     []Extractor
+	checkpoints    *checkpointTracker
+	state          *scanState
+	resumed        bool
+	startTime      time.Time
+	requests       atomic.Int64
+	requestErrors  atomic.Int64
+	matches        atomic.Int64
 }
 
 func NewScanner(cfg config.Config, bar *progressbar.ProgressBar) *Scanner {
@@ -28,24 +43,66 @@ func NewScanner(cfg config.Config, bar *progressbar.ProgressBar) *Scanner {
 		rateLimiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), 1)
 	}
 
+	var baselines *baselineCache
+	if cfg.AutoCalibrate {
+		baselines = newBaselineCache()
+	}
+
+	var extractors []Extractor
+	for _, spec := range cfg.Extractors {
+		extractors = append(extractors, newExtractor(spec))
+	}
+
+	var discovered *hostQueue
+	var scopePattern *regexp.Regexp
+	if cfg.Recursive {
+		discovered = newHostQueue()
+		scopePattern = scopeRegexp(cfg.RecursiveScope)
+	}
+
 	return &Scanner{
 		config:         cfg,
 		bar:            bar,
-		targetChan:     make(chan Target, cfg.Concurrency*2),
-		resultChan:     make(chan string, cfg.Concurrency*2),
-		clients:        newClientCache(cfg.FollowRedirects),
+		targetChan:     make(chan Target, cfg.Concurrency*max(2, cfg.Pipeline)), // Room for a full pipeline per worker
+		resultChan:     make(chan Result, cfg.Concurrency*2),
+		clients:        newClientCache(cfg),
 		progressCount:  0,
 		progressMutex:  sync.Mutex{},
 		lastUpdateTime: time.Now(),
 		rateLimiter:    rateLimiter,
+		baselines:      baselines,
+		discovered:     discovered,
+		scopePattern:   scopePattern,
+		matchers:       newMatcherSet(cfg.MatchRules, cfg.MatchAll),
+		extractors:     extractors,
 	}
 }
 
-func (s *Scanner) Run() {
+// Run scans all targets. Cancelling ctx stops emitting targets and lets the
+// requests in flight finish, after which the outputs are flushed and the
+// final statistics printed.
+func (s *Scanner) Run(ctx context.Context) {
+	s.startTime = time.Now()
+
+	if s.config.ResumeFile != "" {
+		if err := s.loadResumeState(); err != nil {
+			fmt.Printf("Error loading resume state: %v\n", err)
+			return
+		}
+		if s.state.Finished {
+			fmt.Printf("[+] Scan in %s is already completed\n", s.config.ResumeFile)
+			return
+		}
+		if s.resumed {
+			fmt.Printf("[*] Resuming scan, %d targets already done\n", s.state.Completed)
+		}
+	}
+
 	processor, err := NewBatchProcessor(
 		s.config.IPsFile,
 		s.config.HostsFile,
 		s.config.Paths,
+		s.config.Ports,
 		s.targetChan,
 	)
 	if err != nil {
@@ -54,21 +111,105 @@ func (s *Scanner) Run() {
 	}
 	defer processor.Close()
 
+	writers, err := newResultWriters(s.config.OutputFile, s.config.OutputFormats, s.resumed)
+	if err != nil {
+		fmt.Printf("Error opening output file: %v\n", err)
+		return
+	}
+
+	if s.baselines != nil {
+		processor.onIPChunk = s.calibrateIPs
+		if s.discovered == nil {
+			// Discovered hosts are tested against all IPs after the chunk loop,
+			// so recursive scans keep every baseline
+			processor.onIPDone = s.releaseBaselines
+		}
+	}
+
+	if s.config.HarvestCerts && s.resumed && s.state.HarvestedHosts != nil {
+		// Reuse the names of the interrupted scan, so the target order stays the same
+		processor.extraHosts = s.state.HarvestedHosts
+		s.growTotal(s.state.HarvestEndpoints * int64(len(s.config.Paths)) * int64(len(s.state.HarvestedHosts)))
+	} else if s.config.HarvestCerts {
+		fmt.Println("[*] Harvesting hostnames from TLS certificates...")
+		harvested, endpoints, err := s.harvestCertificates(ctx)
+		if err != nil {
+			fmt.Printf("Error harvesting certificates: %v\n", err)
+			return
+		}
+		fmt.Printf("[+] Harvested %d new hostnames from TLS certificates\n", len(harvested))
+
+		if s.state != nil && ctx.Err() == nil {
+			s.state.HarvestedHosts = harvested
+			s.state.HarvestEndpoints = endpoints
+		}
+
+		if s.config.HarvestOutput != "" {
+			if err := writeHostnames(s.config.HarvestOutput, harvested); err != nil {
+				fmt.Printf("Error writing harvested hostnames: %v\n", err)
+			}
+		}
+
+		processor.extraHosts = harvested
+		s.growTotal(endpoints * int64(len(s.config.Paths)) * int64(len(harvested)))
+	}
+
+	if s.discovered != nil {
+		if err := s.seedDiscovered(processor.extraHosts); err != nil {
+			fmt.Printf("Error reading hosts file: %v\n", err)
+			return
+		}
+		processor.discovered = s.discovered
+		processor.onGrow = s.growTotal
+		stop := context.AfterFunc(ctx, s.discovered.stop)
+		defer stop()
+
+		if s.resumed {
+			// Hosts discovered before the interruption are tested again in full
+			for _, host := range s.state.Discovered {
+				s.discovered.push(host.Hostname, host.Depth)
+			}
+		}
+	}
+
+	if s.checkpoints != nil {
+		processor.tracker = s.checkpoints
+		processor.resumeAt = s.state.Position
+	}
+
 	go func() {
-		if err := processor.ProcessFilesChunked(); err != nil {
+		if err := processor.ProcessFilesChunked(ctx); err != nil && ctx.Err() == nil {
 			fmt.Printf("Error processing files: %v\n", err)
 		}
 	}()
 
 	pool := NewWorkerPool(s.config.Concurrency, s)
-	pool.Start()
+	pool.Start(ctx)
 
 	done := make(chan struct{})
-	go s.processResults(done)
+	go s.processResults(writers, done)
 
 	pool.Wait()
 	close(s.resultChan)
 	<-done
+
+	interrupted := ctx.Err() != nil
+	stats := s.finalStats(interrupted)
+	if s.checkpoints != nil {
+		s.state.Stats = &stats
+		s.checkpoint(writers, !interrupted)
+	}
+
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			fmt.Printf("Error closing output: %v\n", err)
+		}
+	}
+
+	printStats(stats)
+	if interrupted && s.config.ResumeFile != "" {
+		fmt.Printf("[*] Progress saved, rerun with -resume %s to continue\n", s.config.ResumeFile)
+	}
 }
 
 func (s *Scanner) updateProgress() {
@@ -82,9 +223,45 @@ func (s *Scanner) updateProgress() {
 	s.progressMutex.Unlock()
 }
 
-func (s *Scanner) processResults(done chan struct{}) {
-	for result := range s.resultChan {
-		fmt.Println(result)
+// growTotal extends the progress bar when targets are added during the scan
+func (s *Scanner) growTotal(n int64) {
+	if n == 0 {
+		return
+	}
+	s.progressMutex.Lock()
+	s.bar.ChangeMax64(s.bar.GetMax64() + n)
+	s.progressMutex.Unlock()
+}
+
+func (s *Scanner) processResults(writers []ResultWriter, done chan struct{}) {
+	var checkpointTicks <-chan time.Time
+	if s.checkpoints != nil {
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		checkpointTicks = ticker.C
+	}
+
+results:
+	for {
+		select {
+		case result, ok := <-s.resultChan:
+			if !ok {
+				break results
+			}
+			s.writeResult(writers, result)
+		case <-checkpointTicks:
+			s.checkpoint(writers, false)
+		}
 	}
 	close(done)
 }
+
+func (s *Scanner) writeResult(writers []ResultWriter, result Result) {
+	s.matches.Add(1)
+	fmt.Println(formatResult(result))
+	for _, w := range writers {
+		if err := w.Write(result); err != nil {
+			fmt.Printf("Error writing result: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package scanner
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math/bits"
+	"unicode"
+	"unicode/utf8"
+)
+
+// hostPlaceholder replaces every occurrence of the requested Host value
+// before hashing, so pages reflecting the Host header hash alike.
+var hostPlaceholder = []byte(" vhostfuzzerhost ")
+
+// simhash computes a 64 bit locality-sensitive hash over the token bigrams
+// of body. Similar bodies produce hashes with a small Hamming distance.
+func simhash(body []byte, host string) uint64 {
+	lower := bytes.ToLower(body)
+	if host != "" {
+		lower = bytes.ReplaceAll(lower, bytes.ToLower([]byte(host)), hostPlaceholder)
+	}
+
+	tokens := bytes.FieldsFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != utf8.RuneError
+	})
+	if len(tokens) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	h := fnv.New64a()
+	addFeature := func(parts ...[]byte) {
+		h.Reset()
+		for _, part := range parts {
+			h.Write(part)
+			h.Write([]byte{0})
+		}
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	if len(tokens) == 1 {
+		addFeature(tokens[0])
+	}
+	for i := 1; i < len(tokens); i++ {
+		addFeature(tokens[i-1], tokens[i])
+	}
+
+	var fingerprint uint64
+	for i := 0; i < 64; i++ {
+		if weights[i] > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+	return fingerprint
+}
+
+// similarityPercent returns how similar two simhashes are, from 0 to 100.
+// Unrelated bodies differ in about half of the 64 bits, so 32 or more
+// differing bits score 0.
+func similarityPercent(a, b uint64) int {
+	distance := bits.OnesCount64(a ^ b)
+	return max(0, 32-distance) * 100 / 32
+}
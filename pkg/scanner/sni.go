@@ -0,0 +1,20 @@
+package scanner
+
+import "github.com/dsecuredcom/vhost-fuzzer/pkg/config"
+
+// sniName returns the TLS server name sent for target, or "" if no SNI is
+// sent. Go never sends IP literals as SNI, so the "none" mode simply keeps
+// the IP in the request URL.
+func (s *Scanner) sniName(target Target, protocol string) string {
+	if protocolScheme(protocol) != "https" {
+		return ""
+	}
+
+	switch s.config.SNIMode {
+	case config.SNIHost:
+		return target.Hostname
+	case config.SNIFixed:
+		return s.config.SNIName
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"fmt"
+	"time"
+)
+
+// scanStats summarizes a scan run. Counters cover the current run only, so
+// after a resume Completed includes targets done in previous runs.
+type scanStats struct {
+	Targets     int64   `json:"targets"`
+	Completed   int64   `json:"completed"`
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	Matches     int64   `json:"matches"`
+	Duration    string  `json:"duration"`
+	RequestRate float64 `json:"requests_per_second"`
+	Interrupted bool    `json:"interrupted"`
+}
+
+func (s *Scanner) finalStats(interrupted bool) scanStats {
+	s.progressMutex.Lock()
+	completed := s.progressCount
+	s.bar.Set64(completed)
+	s.progressMutex.Unlock()
+
+	elapsed := time.Since(s.startTime)
+	requests := s.requests.Load()
+	return scanStats{
+		Targets:     s.bar.GetMax64(),
+		Completed:   completed,
+		Requests:    requests,
+		Errors:      s.requestErrors.Load(),
+		Matches:     s.matches.Load(),
+		Duration:    elapsed.Round(time.Millisecond).String(),
+		RequestRate: float64(requests) / elapsed.Seconds(),
+		Interrupted: interrupted,
+	}
+}
+
+func printStats(stats scanStats) {
+	fmt.Println()
+	if stats.Interrupted {
+		fmt.Println("[!] Scan interrupted")
+	}
+	fmt.Printf("[+] Targets: %d/%d, requests: %d (%d errors, %.1f/s), matches: %d, duration: %s\n",
+		stats.Completed, stats.Targets, stats.Requests, stats.Errors, stats.RequestRate, stats.Matches, stats.Duration)
+}
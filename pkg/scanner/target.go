@@ -1,7 +1,90 @@
 package scanner
 
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
 type Target struct {
 	IP       string
+	Port     int // 0 means the default port of the protocol
 	Hostname string
 	Path     string
+	Depth    int             // Recursion depth, 0 for hosts from the hosts file
+	seq      int64           // Checkpoint sequence number, -1 if not tracked
+	chunk    *sync.WaitGroup // Unfinished targets of the IP chunk, nil if not tracked
+}
+
+// endpoint is an IP (or opaque host) and port read from the IPs file
+type endpoint struct {
+	IP   string
+	Port int
+}
+
+// effectivePort returns the port a request for protocol is sent to
+func (t Target) effectivePort(protocol string) int {
+	if t.Port != 0 {
+		return t.Port
+	}
+	return defaultPort(protocol)
+}
+
+// address returns the ip:port the request for protocol connects to
+func (t Target) address(protocol string) string {
+	return net.JoinHostPort(t.IP, strconv.Itoa(t.effectivePort(protocol)))
+}
+
+// url returns the request URL for protocol, omitting default ports
+func (t Target) url(protocol string) string {
+	return t.urlWithHost(protocol, t.IP)
+}
+
+// urlWithHost returns the request URL with host in place of the IP. The
+// connection still goes to the IP, but the TLS SNI is derived from host.
+func (t Target) urlWithHost(protocol, host string) string {
+	return protocolScheme(protocol) + "://" + t.authority(protocol, host) + t.Path
+}
+
+// authority returns host as used in URLs, with the port unless it is the
+// default one for protocol
+func (t Target) authority(protocol, host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	if t.Port != 0 && t.Port != defaultPort(protocol) {
+		host += ":" + strconv.Itoa(t.Port)
+	}
+	return host
+}
+
+// hostHeader returns the Host header value, optionally including the port
+// when it is not the default one for protocol
+func (t Target) hostHeader(protocol string, withPort bool) string {
+	if withPort && t.Port != 0 && t.Port != defaultPort(protocol) {
+		return net.JoinHostPort(t.Hostname, strconv.Itoa(t.Port))
+	}
+	return t.Hostname
+}
+
+func defaultPort(protocol string) int {
+	if protocolScheme(protocol) == "https" {
+		return 443
+	}
+	return 80
+}
+
+// protocolScheme returns the URL scheme of protocol. HTTP/2 runs over TLS
+// (h2) or cleartext (h2c).
+func protocolScheme(protocol string) string {
+	switch protocol {
+	case "https", "h2":
+		return "https"
+	}
+	return "http"
+}
+
+// isHTTP2 reports whether protocol is sent over HTTP/2
+func isHTTP2(protocol string) bool {
+	return protocol == "h2" || protocol == "h2c"
 }
@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// metadataScanSize is the number of body bytes searched for metadata
+	metadataScanSize = 64 * 1024
+	// maxTitleLength is the maximum length of extracted texts in runes
+	maxTitleLength = 256
+)
+
+// pageMetadata is the descriptive information of an HTML page
+type pageMetadata struct {
+	Title     string
+	Generator string // <meta name="generator">
+	OGTitle   string // <meta property="og:title">
+	H1        string // Text of the first <h1>
+}
+
+// extractMetadata tokenizes the beginning of body, converted to UTF-8 using
+// the charset of contentType, a BOM or a <meta charset> declaration. Bodies
+// declared as something other than HTML or XML are skipped.
+func extractMetadata(body []byte, contentType string) pageMetadata {
+	var meta pageMetadata
+	if len(body) == 0 {
+		return meta
+	}
+	if ct := strings.ToLower(contentType); ct != "" && !strings.Contains(ct, "html") && !strings.Contains(ct, "xml") {
+		return meta
+	}
+	if len(body) > metadataScanSize {
+		body = body[:metadataScanSize]
+	}
+
+	var reader io.Reader = bytes.NewReader(body)
+	if decoded, err := charset.NewReader(reader, contentType); err == nil {
+		reader = decoded
+	}
+
+	tokenizer := html.NewTokenizer(reader)
+	var title, h1 *strings.Builder
+	titleDone, h1Done := false, false
+	svgDepth := 0 // SVG images have titles of their own
+
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			// A title without </title> runs to the end of the document, only its
+			// first line is kept
+			if title != nil {
+				text, _, _ := strings.Cut(title.String(), "\n")
+				meta.Title = collapseText(text)
+			}
+			if h1 != nil {
+				meta.H1 = collapseText(h1.String())
+			}
+			return meta
+
+		case html.TextToken:
+			if title != nil {
+				title.Write(tokenizer.Text())
+			}
+			if h1 != nil {
+				h1.Write(tokenizer.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tn, hasAttr := tokenizer.TagName()
+			switch atom.Lookup(tn) {
+			case atom.Svg:
+				if tt == html.StartTagToken {
+					svgDepth++
+				}
+			case atom.Title:
+				if !titleDone && svgDepth == 0 && tt == html.StartTagToken {
+					title = &strings.Builder{}
+				}
+			case atom.H1:
+				if !h1Done && tt == html.StartTagToken {
+					h1 = &strings.Builder{}
+				}
+			case atom.Meta:
+				if hasAttr {
+					readMetaTag(tokenizer, &meta)
+				}
+			}
+
+		case html.EndTagToken:
+			tn, _ := tokenizer.TagName()
+			switch atom.Lookup(tn) {
+			case atom.Svg:
+				if svgDepth > 0 {
+					svgDepth--
+				}
+			case atom.Title:
+				if title != nil {
+					meta.Title = collapseText(title.String())
+					title, titleDone = nil, true
+				}
+			case atom.H1:
+				if h1 != nil {
+					meta.H1 = collapseText(h1.String())
+					h1, h1Done = nil, true
+				}
+			}
+		}
+
+		if titleDone && h1Done && meta.Generator != "" && meta.OGTitle != "" {
+			return meta
+		}
+	}
+}
+
+// readMetaTag records the generator and og:title meta tags
+func readMetaTag(tokenizer *html.Tokenizer, meta *pageMetadata) {
+	var name, content string
+	for {
+		key, value, more := tokenizer.TagAttr()
+		switch string(key) {
+		case "name", "property":
+			name = strings.ToLower(strings.TrimSpace(string(value)))
+		case "content":
+			content = string(value)
+		}
+		if !more {
+			break
+		}
+	}
+
+	switch {
+	case name == "generator" && meta.Generator == "":
+		meta.Generator = collapseText(content)
+	case name == "og:title" && meta.OGTitle == "":
+		meta.OGTitle = collapseText(content)
+	}
+}
+
+// collapseText trims s, collapses whitespace runs into single spaces and
+// limits it to maxTitleLength runes
+func collapseText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= maxTitleLength {
+		return s
+	}
+	return string([]rune(s)[:maxTitleLength]) + "..."
+}
@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"context"
 	"sync"
 
 	"github.com/valyala/fasthttp"
@@ -10,16 +11,21 @@ type WorkerPool struct {
 	workers     int
 	scanner     *Scanner
 	workerGroup sync.WaitGroup
+	pipelines   *pipelineSupport // nil unless -pipeline is set
 }
 
 func NewWorkerPool(workers int, scanner *Scanner) *WorkerPool {
-	return &WorkerPool{
+	wp := &WorkerPool{
 		workers: workers,
 		scanner: scanner,
 	}
+	if scanner.config.Pipeline > 0 {
+		wp.pipelines = newPipelineSupport()
+	}
+	return wp
 }
 
-func (wp *WorkerPool) Start() {
+func (wp *WorkerPool) Start(ctx context.Context) {
 	reqPool := sync.Pool{
 		New: func() interface{} {
 			return &fasthttp.Request{}
@@ -33,11 +39,15 @@ func (wp *WorkerPool) Start() {
 
 	for i := 0; i < wp.workers; i++ {
 		wp.workerGroup.Add(1)
-		go wp.worker(&reqPool, &respPool)
+		if wp.pipelines != nil {
+			go wp.pipelineWorker(ctx)
+		} else {
+			go wp.worker(ctx, &reqPool, &respPool)
+		}
 	}
 }
 
-func (wp *WorkerPool) worker(reqPool, respPool *sync.Pool) {
+func (wp *WorkerPool) worker(ctx context.Context, reqPool, respPool *sync.Pool) {
 	defer wp.workerGroup.Done()
 
 	req := reqPool.Get().(*fasthttp.Request)
@@ -49,10 +59,38 @@ func (wp *WorkerPool) worker(reqPool, respPool *sync.Pool) {
 	}()
 
 	for target := range wp.scanner.targetChan {
-		if result := wp.scanner.checkTarget(target, req, resp); result != "" {
-			wp.scanner.resultChan <- result
-		}
-		wp.scanner.updateProgress()
+		wp.process(ctx, target, req, resp)
+	}
+}
+
+func (wp *WorkerPool) process(ctx context.Context, target Target, req *fasthttp.Request, resp *fasthttp.Response) {
+	if wp.scanner.discovered != nil {
+		defer wp.scanner.discovered.targetDone()
+	}
+
+	// After shutdown was requested, queued targets are dropped and interrupted
+	// ones are not marked as finished, so a resumed scan tests them again
+	if ctx.Err() != nil {
+		return
+	}
+	results, err := wp.scanner.checkTarget(ctx, target, req, resp)
+	if err != nil {
+		return
+	}
+	wp.finish(target, results)
+}
+
+// finish hands the results of a target to the writers and marks it as done
+func (wp *WorkerPool) finish(target Target, results []Result) {
+	for _, result := range results {
+		wp.scanner.resultChan <- result
+	}
+	wp.scanner.updateProgress()
+	if wp.scanner.checkpoints != nil && target.seq >= 0 {
+		wp.scanner.checkpoints.finished(target.seq)
+	}
+	if target.chunk != nil {
+		target.chunk.Done()
 	}
 }
 